@@ -49,7 +49,7 @@ func main() {
 			log.Println(width, height)
 		// And the main game status updates
 		case *aisandbox.GameInfo:
-			var target []float64
+			var target aisandbox.Vec2
 			var text string
 			var team *aisandbox.TeamInfo
 
@@ -67,7 +67,7 @@ func main() {
 
 			for _, bot := range m.Team.Members {
 				// Skip dead bots and bots who already have something to do.
-				if bot.State > 1 {
+				if !bot.IsAlive() || bot.State.IsBusy() {
 					continue
 				}
 
@@ -93,8 +93,8 @@ func main() {
 				case 2:
 					// Attack random point on the map
 					// XXX: Doesn't check if target is possible.
-					target = []float64{rand.Float64() * width, rand.Float64() * height}
-					text = fmt.Sprintf("Attacking [%.2f, %.2f].", target[0], target[1])
+					target = aisandbox.V(rand.Float64()*width, rand.Float64()*height)
+					text = fmt.Sprintf("Attacking [%.2f, %.2f].", target.X(), target.Y())
 				}
 
 				r = rand.Intn(4)
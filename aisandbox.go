@@ -5,167 +5,581 @@ package aisandbox
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	// If the api_version conflicts with the one sent from server,
-	// try upgrading both the AI sandbox and these bindings.
+	// Latest protocol version these bindings know. Older versions are handled by the codecs
+	// registered in codec.go, the one matching the server is picked at handshake.
+	// If the server version has no codec, try upgrading both the AI sandbox and these bindings.
 	// If these bindings are still using old version, feel free to contact me at IRC.
 	// I can most probably be found at #gameai on Freenode.
 	api_version = "1.4"
 )
 
-// Bot states
-const (
-	STATE_UNKNOWN = iota
-	STATE_IDLE
-	STATE_DEFENDING
-	STATE_MOVING
-	STATE_ATTACKING
-	STATE_CHARGING
-	STATE_SHOOTING
-	TAKING_ORDERS
-	STATE_HOLDING
-	STATE_DEAD
-)
+// Client is a single connection to the game server.
+// Every Client owns its connection and its reader and writer goroutines,
+// so one process can run several commanders side by side (eg. self-play).
+type Client struct {
+	name string
+	conn io.ReadWriteCloser
+	in   chan interface{} // legacy channel, see In()
+	out  chan Command
 
-// CombatEvent types
-const (
-	EVENT_NONE = iota
-	EVENT_KILL
-	EVENT_FLAG_PICKED
-	EVENT_FLAG_DROPPED
-	EVENT_FLAG_CAPTURED
-	EVENT_FLAG_RESTORED
-	EVENT_RESPAWN
-)
+	events chan Event
+	// Closed once the commander picked either Events() or In().
+	mode     chan struct{}
+	modeOnce sync.Once
+	legacy   bool // In() is used instead of Events(), set before mode is closed
 
-var (
-	conn net.Conn
-)
+	// From the reader to dispatch() and from dispatch() to the writer.
+	incoming chan Event
+	outgoing chan stampedCommand
+	// Ready() requests, passed to the writer in order with the commands
+	readyReq chan chan error
+	// Closed when dispatch() stops
+	dispatched chan struct{}
+
+	seen    atomic.Int64 // GameInfos received by the commander, dropped ones included
+	dropped atomic.Int64 // see Dropped()
+
+	// Stall detection, see StallTimeout()
+	waitingSince atomic.Int64 // when the reader started waiting for the current frame, 0 if it's not waiting
+	ticking      atomic.Bool  // the first <tick> has been read
+	stalledSince int64        // waitingSince of the latest Stalled event, only used by dispatch()
+	watchdog     *time.Timer  // drives checkStall, nil if stall detection is off. Only dispatch() may touch this.
+	stalls       []Event      // Stalled events for dispatch() to deliver
+
+	// Cancelling ctx stops the reader, the writer and dispatch().
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Serializes writes so that Ready() can't interleave with commands.
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	// <ready> has been sent, only set while holding wmu
+	readied atomic.Bool
+	// Started by the reader for AutoReady()
+	readyTimer *time.Timer
+
+	// Codec picked at handshake, guarded by wmu.
+	codec Codec
+	// Set by the reader at handshake in ReuseGameInfo() mode, before the first Tick.
+	decoder *gameDecoder
+	// GameInfo of the latest Tick the commander received, released to the decoder
+	// once it receives the next Tick. Only dispatch() may touch this.
+	lastGame *GameInfo
+	// Latest GameInfo the commander received, for Diffs(). Only dispatch() may touch this.
+	prevGame *GameInfo
+	// The ended hook of Replay has been called. Only dispatch() may touch this.
+	ended bool
+
+	// Errors are reported here, closed once both reader and writer have stopped.
+	errs chan error
+	// errs has been closed, guarded by errMu. Fanout and AutoReady() may still report after that.
+	errsClosed bool
+	// First error that ended the session
+	err   error
+	errMu sync.Mutex
+	// First error reported during the session, returned by Close()
+	first error
+
+	// Closed by Close(), see dispatch()
+	closing   chan struct{}
+	closeOnce sync.Once
+	// Closed once every goroutine of the session has stopped
+	done chan struct{}
+
+	opts    *options
+	latency latencyTracker
+	metrics *metrics
+	logger  *slog.Logger
+
+	// Events from the writer, delivered before the next event from the reader.
+	pending []Event
+	pendMu  sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// Client used by the package level Connect and Ready.
+var defaultClient *Client
+
+// Creates a new, unconnected client for commander called name.
+func NewClient(name string) *Client {
+	return &Client{name: name}
+}
 
 // Runs in the background and listens for messages from conn
 // then parsing the JSON data into structs and forwarding those
 // to the commander through a channel.
-func listenForGameData(name string, c chan interface{}) {
+func (cl *Client) listenForGameData() {
 	var (
+		final       Event // last event of the session
 		err         error
-		buffer      []byte
+		frame       *Frame
 		message     string
-		gameinfo    *json_GameInfo
-		levelinfo   *json_LevelInfo
+		gameinfo    *GameInfo
+		levelinfo   *LevelInfo
 		initialized bool
+		started     bool // a <tick> has been read
+		games       int  // GameInfos passed on so far, also the index of the next one, see lastSeen
+		read        time.Time
+		codec       = LookupCodec(api_version)
 		bufConn     *bufio.Reader
 	)
+	defer cl.wg.Done()
+	defer cl.stopAutoReady()
 	// Buffer the connection so we can read it line by line
-	bufConn = bufio.NewReader(conn)
+	bufConn = bufio.NewReader(countingReader{cl.conn, cl.metrics.bytesRead})
 
 loop:
 	for {
-		if buffer, err = bufConn.ReadBytes('\n'); err != nil {
-			log.Println(err)
+		cl.waitingFrame(true)
+		frame, err = ReadFrame(bufConn)
+		cl.waitingFrame(false)
+		read = time.Now()
+		if len(frame.Raw) > 0 {
+			cl.record(DIR_RECV, frame, cl.lastSeen())
+		}
+		if err != nil {
+			// If the writer failed first, that's the reason for the lost connection.
+			if failure := cl.failure(); failure != nil {
+				err = failure
+			} else {
+				err = &Error{Kind: ErrEOF, Frame: frame.Header, Err: err}
+				cl.report(err)
+			}
+			final = &Disconnected{Err: err}
 			break
 		}
-		message = strings.TrimSpace(string(buffer))
+		message = frame.Header
+		if message != "" {
+			cl.metrics.framesReceived.Add(message, 1)
+		}
 		switch message {
 		case "":
-			log.Println("Empty line from conn")
+			cl.logFrame(slog.LevelDebug, message, "Empty line from conn")
 			continue loop
 		case "<connect>":
 			// Server handshake
-			connect := new(json_ConnectServer)
-			if err = jsonFromBuffer(bufConn, &connect); err != nil {
-				log.Println(err)
+			var connect json_ConnectServer
+			if err = json.Unmarshal(frame.Payload[0], &connect); err == nil && connect.Value.ProtocolVersion == "" {
+				err = errors.New("protocol version missing")
+			}
+			if err != nil {
+				cl.report(&Error{Kind: ErrDecode, Frame: message, Err: err})
 				continue
 			}
 
-			if api_version != connect.Value.ProtocolVersion {
-				log.Printf("Wrong json api version.\nServer: %s\nClient: %s", connect.Value.ProtocolVersion, api_version)
+			if codec = LookupCodec(connect.Value.ProtocolVersion); codec == nil {
+				err = &Error{
+					Kind:  ErrVersionMismatch,
+					Frame: message,
+					Err:   fmt.Errorf("server %s, client supports %v", connect.Value.ProtocolVersion, Versions()),
+				}
+				cl.report(err)
+				final = &Disconnected{Err: err}
 				break loop
 			}
 
 			reply := &json_ClientConnect{
 				Class: "ConnectClient",
 			}
-			reply.Value.CommanderName = name
+			reply.Value.CommanderName = cl.name
 			reply.Value.Language = "Go"
 			b, _ := json.Marshal(reply)
 
-			// Client handshake
-			conn.Write([]byte("<connect>\n"))
-			conn.Write(trim(b))
+			// Client handshake, commands are encoded with the same codec from now on
+			cl.wmu.Lock()
+			cl.codec = codec
+			cl.wmu.Unlock()
+			if c, ok := codec.(reusableCodec); ok && cl.opts.reuse {
+				cl.decoder = c.newGameDecoder()
+			}
+			if err = cl.write("<connect>", trim(b)); err != nil {
+				cl.report(err)
+				final = &Disconnected{Err: err}
+				break loop
+			}
+			cl.logFrame(slog.LevelInfo, message, "Connected", "version", connect.Value.ProtocolVersion)
 		case "<initialize>":
 			if initialized {
-				log.Printf("Unexpected initialize message '%s'", message)
+				cl.report(&Error{Kind: ErrUnexpected, Frame: message, Err: errors.New("already initialized")})
+				continue
 			}
-			// Read level info
-			levelinfo = new(json_LevelInfo)
-			if err = jsonFromBuffer(bufConn, &levelinfo); err != nil {
-				log.Println(err)
+			if levelinfo, err = codec.DecodeLevelInfo(frame.Payload[0]); err != nil {
+				cl.report(&Error{Kind: ErrDecode, Frame: message, Err: err})
 				continue
 			}
-			// Read game info
-			gameinfo = new(json_GameInfo)
-			if err = jsonFromBuffer(bufConn, &gameinfo); err != nil {
-				log.Println(err)
+			if gameinfo = cl.decodeGameInfo(codec, message, frame.Payload[1]); gameinfo == nil {
 				continue
 			}
-			// Only send the actual data of LevelInfo
-			c <- levelinfo.Value
-			// Make GameInfo more intuitive to use
-			c <- gameinfo.simplify()
+			cl.initialized(read, levelinfo)
+			if !cl.deliver(&LevelLoaded{levelinfo}) || !cl.deliver(&GameStarted{gameinfo}) {
+				break loop
+			}
+			games++
 			initialized = true
 		case "<tick>":
 			if !initialized {
-				log.Printf("Unexpected message '%s' while waiting for initialize", message)
+				cl.report(&Error{Kind: ErrUnexpected, Frame: message, Err: errors.New("waiting for <initialize>")})
 			}
-			gameinfo = new(json_GameInfo)
-			if err = jsonFromBuffer(bufConn, &gameinfo); err != nil {
-				log.Println(err)
+			if gameinfo = cl.decodeGameInfo(codec, message, frame.Payload[0]); gameinfo == nil {
 				continue
 			}
-			c <- gameinfo.simplify()
+			cl.latency.frame(games, read)
+			if !started && initialized && !cl.readied.Load() {
+				if !cl.deliver(&ReadyMissed{InitializationTime: seconds(levelinfo.InitializationTime)}) {
+					break loop
+				}
+			}
+			started = true
+			cl.ticking.Store(true)
+			if gameinfo.Match != nil {
+				cl.metrics.lastTimePassed.Set(gameinfo.Match.TimePassed)
+			}
+			if !cl.deliver(&Tick{Game: gameinfo}) {
+				break loop
+			}
+			games++
 		case "<shutdown>":
 			if !initialized {
-				log.Printf("Unexpected message '%s' while waiting for initialize", message)
+				cl.report(&Error{Kind: ErrUnexpected, Frame: message, Err: errors.New("waiting for <initialize>")})
 			}
+			final = &Shutdown{Reason: REASON_SERVER}
 			break loop
 		default:
-			log.Printf("unknown message received: '%s'", message)
+			cl.report(&Error{Kind: ErrUnknownMessage, Frame: message})
 		}
 	}
 	// Tell the commander that we're done here.
-	close(c)
+	cl.logEnd(final)
+	if final != nil {
+		cl.deliver(final)
+	}
+	close(cl.incoming)
+}
+
+// Decodes the GameInfo payload of message, nil if it couldn't be decoded.
+// A GameInfo with inconsistencies is reported and returned as it is.
+func (cl *Client) decodeGameInfo(codec Codec, message string, b []byte) *GameInfo {
+	var (
+		gameinfo *GameInfo
+		err      error
+	)
+	if message == "<tick>" && cl.decoder != nil {
+		gameinfo, err = cl.decoder.decode(b)
+	} else {
+		gameinfo, err = codec.DecodeGameInfo(b)
+	}
+	var inconsistent Inconsistencies
+	switch {
+	case err == nil:
+	case gameinfo != nil && errors.As(err, &inconsistent):
+		cl.report(&Error{Kind: ErrInconsistent, Frame: message, Err: err})
+	default:
+		cl.report(&Error{Kind: ErrDecode, Frame: message, Err: err})
+		return nil
+	}
+	return gameinfo
+}
+
+// Ends the session because of err. The reader notices the closed connection
+// and sends Disconnected with err to the commander.
+func (cl *Client) fail(err error) {
+	cl.errMu.Lock()
+	if cl.err == nil {
+		cl.err = err
+	}
+	cl.errMu.Unlock()
+	cl.report(err)
+	cl.conn.Close()
+}
+
+// Returns the error passed to fail, if any.
+func (cl *Client) failure() error {
+	cl.errMu.Lock()
+	defer cl.errMu.Unlock()
+	return cl.err
+}
+
+// Logs err and passes it on to Errors() channel.
+// Errors caused by closing the session ourselves are dropped.
+// NOTE: If nobody reads Errors() the oldest errors are dropped to keep the session running.
+func (cl *Client) report(err error) {
+	if errors.Is(err, ErrDecode) {
+		cl.metrics.decodeErrors.Add(1)
+	}
+	if cl.ctx.Err() != nil {
+		return
+	}
+	cl.errMu.Lock()
+	defer cl.errMu.Unlock()
+	if cl.errsClosed {
+		return
+	}
+	if cl.first == nil {
+		cl.first = err
+	}
+	cl.logError(err)
+	for {
+		select {
+		case cl.errs <- err:
+			return
+		default:
+		}
+		select {
+		case <-cl.errs:
+		default:
+		}
+	}
+}
+
+// Passes ev on to dispatch(). Returns false if the session was cancelled first.
+// NOTE: Only the reader may call this, it's the one closing incoming.
+func (cl *Client) deliver(ev Event) bool {
+	select {
+	case cl.incoming <- ev:
+		return true
+	case <-cl.ctx.Done():
+		return false
+	}
+}
+
+// Counts GameInfos that the commander has received, see lastSeen.
+func (cl *Client) countGameInfo(ev Event) {
+	switch e := ev.(type) {
+	case *GameStarted:
+		cl.seen.Add(1)
+	case *Tick:
+		cl.seen.Add(int64(e.Dropped) + 1)
+	}
+}
+
+// Called by dispatch() once the commander has received ev.
+// In ReuseGameInfo() mode the GameInfo of the previous Tick can be reused from now on,
+// it's kept until the next Tick though since Diffs() compares against it.
+func (cl *Client) delivered(ev Event) {
+	cl.countGameInfo(ev)
+	switch e := ev.(type) {
+	case *GameStarted:
+		cl.prevGame = e.Game
+	case *Tick:
+		cl.prevGame = e.Game
+		if cl.lastGame != nil {
+			cl.decoder.release(cl.lastGame)
+			cl.lastGame = nil
+		}
+		if cl.decoder != nil {
+			cl.lastGame = e.Game
+		}
+	}
+}
+
+// Index of the latest GameInfo the commander has received: 0 for the one in <initialize>,
+// 1 for the first <tick> and so on. -1 before the first one.
+func (cl *Client) lastSeen() int {
+	return int(cl.seen.Load()) - 1
+}
+
+// Queues ev to be delivered before the next event from the reader. Used by the writer,
+// which must never block on events while the commander may be blocked sending on Out().
+func (cl *Client) queue(ev Event) {
+	cl.pendMu.Lock()
+	cl.pending = append(cl.pending, ev)
+	cl.pendMu.Unlock()
+}
+
+// Takes the events queued by the writer.
+func (cl *Client) takeQueued() []Event {
+	cl.pendMu.Lock()
+	defer cl.pendMu.Unlock()
+	pending := cl.pending
+	cl.pending = nil
+	return pending
 }
 
 // Runs in the background and listens to the channel for commands sent by the commander.
-func listenForPlayerCommands(c chan Command) {
-	for v := range c {
-		conn.Write([]byte("<command>\n"))
-		conn.Write(v.JSON())
+// Stops when Out() is closed or the session is cancelled.
+func (cl *Client) listenForPlayerCommands() {
+	defer cl.wg.Done()
+	for {
+		select {
+		case v, ok := <-cl.outgoing:
+			if !ok {
+				cl.cancel()
+				return
+			}
+			if err := cl.failure(); err != nil {
+				// Keep taking commands so that the commander doesn't block before noticing the end.
+				if v.ready != nil {
+					v.ready <- err
+				}
+				continue
+			}
+			closed, err := cl.writeCommands(v)
+			if err != nil {
+				cl.fail(err)
+				continue
+			}
+
+			if closed {
+				cl.cancel()
+				return
+			}
+		case <-cl.ctx.Done():
+			return
+		}
 	}
+}
 
-	conn.Close()
+// Closes the connection once the session is cancelled, which also unblocks the reader.
+func (cl *Client) closeOnCancel() {
+	defer cl.wg.Done()
+	<-cl.ctx.Done()
+	cl.conn.Close()
 }
 
-// NOTE: AiSandbox spec says that messages can't contain newlines.
-func jsonFromBuffer(bufConn *bufio.Reader, target interface{}) (err error) {
-	var buffer []byte
+// Writes one frame per part with a single flush while holding the write lock.
+// Each frame is in the form "<header>\n" + optional payload.
+func (cl *Client) write(header string, payloads ...[]byte) error {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	if err := cl.bufferFrame(header, cl.lastSeen(), payloads...); err != nil {
+		return err
+	}
+	return cl.flush(header)
+}
 
-	if buffer, err = bufConn.ReadBytes('\n'); err != nil {
-		log.Println(err)
+// Assembles the frame and hands it to the buffered writer in one piece,
+// so a failing connection can't leave half a frame in the stream.
+// NOTE: Must be called while holding wmu.
+func (cl *Client) bufferFrame(header string, tick int, payloads ...[]byte) error {
+	frame := NewFrame(header, payloads...)
+	cl.record(DIR_SEND, frame, tick)
+	if _, err := cl.bw.Write(frame.Raw); err != nil {
+		return &Error{Kind: ErrWrite, Frame: header, Err: err}
+	}
+	return nil
+}
+
+// NOTE: Must be called while holding wmu.
+func (cl *Client) flush(header string) error {
+	if err := cl.bw.Flush(); err != nil {
+		return &Error{Kind: ErrWrite, Frame: header, Err: err}
+	}
+	return nil
+}
+
+// Writes first and every command that is already waiting in Out() with a single flush.
+// Returns closed = true if Out() was closed while picking up the waiting commands.
+// Ready() requests among them are written in order and answered once the batch is flushed.
+func (cl *Client) writeCommands(first stampedCommand) (closed bool, err error) {
+	var (
+		replies []chan error
+		answers []int // ticks the commands were stamped with, in order
+	)
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	defer func() {
+		for _, reply := range replies {
+			reply <- err
+		}
+	}()
+
+	buffer := func(v stampedCommand) error {
+		if v.ready != nil {
+			replies = append(replies, v.ready)
+			_, err := cl.bufferReady()
+			return err
+		}
+		if len(answers) == 0 || answers[len(answers)-1] != v.tick {
+			answers = append(answers, v.tick)
+		}
+		return cl.bufferCommand(v.cmd, v.tick)
+	}
+
+	if err = buffer(first); err != nil {
 		return
 	}
-	json.Unmarshal(buffer, target)
+batch:
+	for {
+		select {
+		case v, ok := <-cl.outgoing:
+			if !ok {
+				closed = true
+				break batch
+			}
+			if err = buffer(v); err != nil {
+				return
+			}
+		default:
+			break batch
+		}
+	}
+	// Measured before flushing, so that a warning is queued before the server can react to the commands.
+	now := time.Now()
+	for _, tick := range answers {
+		if latency, ok := cl.latency.command(tick, now); ok && cl.opts.budget > 0 && latency > cl.opts.budget {
+			cl.queue(&BudgetExceeded{Latency: latency, Budget: cl.opts.budget})
+		}
+	}
+	err = cl.flush("<command>")
 	return
 }
 
+// Encodes cmd with the codec picked at handshake and buffers it. Batches are unpacked.
+// Commands that can't be encoded are reported and skipped, only write errors are returned.
+// NOTE: Must be called while holding wmu.
+func (cl *Client) bufferCommand(cmd Command, tick int) error {
+	if batch, ok := cmd.(Batch); ok {
+		for _, v := range batch {
+			if err := cl.bufferCommand(v, tick); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	b, err := cl.encodeCommand(cmd)
+	if err != nil {
+		cl.report(&Error{Kind: ErrEncode, Frame: "<command>", Err: err})
+		return nil
+	}
+	if err = cl.bufferFrame("<command>", tick, b); err != nil {
+		return err
+	}
+	cl.metrics.commandsSent.Add(commandClass(cmd), 1)
+	return nil
+}
+
+// Encodes cmd with the codec picked at handshake, or the latest one before handshake.
+// NOTE: Must be called while holding wmu.
+func (cl *Client) encodeCommand(cmd Command) ([]byte, error) {
+	codec := cl.codec
+	if codec == nil {
+		codec = LookupCodec(api_version)
+	}
+	return codec.EncodeCommand(cmd)
+}
+
+// Like encodeCommand, but takes the write lock itself.
+func (cl *Client) encode(cmd Command) ([]byte, error) {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	return cl.encodeCommand(cmd)
+}
+
 // Trims newlines and adds one newline to the end.
 func trim(b []byte) []byte {
 	var count int
@@ -182,30 +596,235 @@ func trim(b []byte) []byte {
 	return b
 }
 
+// Returns nil if data can't be encoded, eg. a Vec2 with three values.
+// Sessions encode commands with Codec.EncodeCommand, which reports the error.
 func marshal(data interface{}) []byte {
-	var (
-		buffer []byte
-		err    error
-	)
-
-	if buffer, err = json.Marshal(data); err != nil {
-		log.Println(err)
+	buffer, err := json.Marshal(data)
+	if err != nil {
+		return nil
 	}
 	return trim(buffer)
 }
 
 // Inform the server that the client has processed initialization data and is ready to play.
 //
-// NOTE: The server may start the game before it reaches this ready message depending on the game configuration
-//       There is InitializationTime field in the LevelInfo that contains the information how long the client
-//       can spend on processing the initial data before server starts the game by itself.
-func Ready() {
-	conn.Write([]byte("<ready>\n"))
+// NOTE: The server may start the game before it reaches this ready message depending on the game configuration.
+// There is InitializationTime field in the LevelInfo that contains the information how long the client
+// can spend on processing the initial data before server starts the game by itself.
+//
+// This is the commander's "initialization done" signal. It's sent only once, calling Ready() again does nothing.
+// See AutoReady() option for sending it automatically before InitializationTime runs out.
+//
+// Commands sent to Out() before calling Ready() are written before <ready>.
+//
+// Returns an error of type *Error if the message couldn't be sent.
+func (cl *Client) Ready() error {
+	if cl.readyReq == nil {
+		return &Error{Kind: ErrWrite, Frame: "<ready>", Err: errNotConnected}
+	}
+	if cl.readied.Load() {
+		return nil
+	}
+	reply := make(chan error, 1)
+	select {
+	case cl.readyReq <- reply:
+		return <-reply
+	case <-cl.dispatched:
+		// Nothing to keep in order with anymore.
+		return cl.ready(nil)
+	}
+}
+
+// Opens a connection to the server and starts the reader and writer goroutines.
+// Retries every 500ms for 10 seconds, use ConnectContext for anything else.
+// NOTE: In case of shutdown the In() channel will be closed to inform commander that it should shut down.
+// NOTE: Close Out() channel when finished to close the connection to the server.
+// Params:
+// host, port - address and port of the server
+func (cl *Client) Connect(host string, port int) (err error) {
+	return cl.ConnectContext(context.Background(), net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// Opens a connection to addr ("host:port") and starts the reader and writer goroutines.
+// Dialing is retried according to opts until it succeeds, the retry policy gives up or ctx is done.
+// Cancelling ctx later on ends the session: the connection is closed and In() channel is closed.
+// Commands sent to Out() after that are dropped, close Out() when done as usual.
+func (cl *Client) ConnectContext(ctx context.Context, addr string, opts ...Option) (err error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var conn io.ReadWriteCloser
+	if conn, err = o.dial(ctx, addr); err != nil {
+		o.logger.Error("Failed to connect to the server", "session", cl.name, "addr", addr, "err", err)
+		return
+	}
+
+	cl.start(ctx, conn, o)
+	return
+}
+
+// Runs the session over an already open connection, eg. one end of net.Pipe,
+// a Unix domain socket or Stdio() when the bot is spawned as a child process.
+// Dialing options in opts are ignored.
+// Cancelling ctx ends the session and closes conn.
+func (cl *Client) Open(ctx context.Context, conn io.ReadWriteCloser, opts ...Option) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	cl.start(ctx, conn, o)
+}
+
+// Starts the reader and writer goroutines on conn.
+func (cl *Client) start(ctx context.Context, conn io.ReadWriteCloser, o *options) {
+	cl.conn = conn
+	cl.opts = o
+	cl.logger = o.logger.With("session", cl.name)
+	cl.metrics = newMetrics()
+	if o.metrics != "" {
+		cl.metrics.publish(o.metrics)
+	}
+	cl.bw = bufio.NewWriter(countingWriter{conn, cl.metrics.bytesWritten})
+	cl.ctx, cl.cancel = context.WithCancel(ctx)
+	cl.in, cl.out = make(chan interface{}), make(chan Command)
+	cl.events = make(chan Event)
+	cl.mode = make(chan struct{})
+	cl.incoming, cl.outgoing = make(chan Event), make(chan stampedCommand)
+	cl.readyReq, cl.dispatched = make(chan chan error), make(chan struct{})
+	cl.errs = make(chan error, 16)
+	cl.closing, cl.done = make(chan struct{}), make(chan struct{})
+
+	cl.wg.Add(4)
+	go cl.listenForGameData()
+	go cl.listenForPlayerCommands()
+	go cl.dispatch()
+	go cl.closeOnCancel()
+	go func() {
+		cl.wg.Wait()
+		cl.errMu.Lock()
+		cl.errsClosed = true
+		close(cl.errs)
+		cl.errMu.Unlock()
+		close(cl.done)
+	}()
+}
+
+// Events from the server. The last event is either Shutdown or Disconnected,
+// after which the channel is closed.
+// NOTE: No final event is sent if the session was closed by the commander.
+// NOTE: Use either Events() or In(), not both.
+// The channel is closed already if the client isn't connected.
+func (cl *Client) Events() <-chan Event {
+	if cl.mode == nil {
+		events := make(chan Event)
+		close(events)
+		return events
+	}
+	cl.modeOnce.Do(func() {
+		close(cl.mode)
+	})
+	return cl.events
+}
+
+// Incoming updates, being either LevelInfo or GameInfo structs.
+// Kept for compatibility, see Events() for the typed version.
+func (cl *Client) In() <-chan interface{} {
+	if cl.mode == nil {
+		in := make(chan interface{})
+		close(in)
+		return in
+	}
+	cl.modeOnce.Do(func() {
+		cl.legacy = true
+		close(cl.mode)
+	})
+	return cl.in
+}
+
+// Outgoing channel where commander can send his commands, preferably Defend, Attack, Move or Charge structs.
+func (cl *Client) Out() chan<- Command {
+	return cl.out
+}
+
+// Errors noticed by the session, all of type *Error.
+// Reading it is optional, errors are also logged if Logger() is set. The channel is closed when the session ends,
+// and closed already if the client isn't connected.
+func (cl *Client) Errors() <-chan error {
+	if cl.errs == nil {
+		errs := make(chan error)
+		close(errs)
+		return errs
+	}
+	return cl.errs
+}
+
+// Ends the session: commands already waiting in Out() are written, the connection is closed
+// and Events(), In() and Errors() are closed. Every goroutine of the client has stopped when Close returns,
+// apart from the one dropping commands until Out() is closed.
+// Returns the first error reported during the session, nil if there was none.
+//
+// NOTE: Commands sent to Out() after calling Close are dropped. Close Out() once done sending,
+// the goroutine dropping them stops only then.
+// NOTE: If the pending commands can't be written within CloseTimeout() the connection is closed anyway.
+// NOTE: If closing the connection doesn't interrupt a pending read (os.Stdin outside Unix), Close gives up
+// waiting for the reader after another CloseTimeout(). The channels are closed once that read returns.
+func (cl *Client) Close() error {
+	if cl.conn == nil {
+		return nil
+	}
+	cl.closeOnce.Do(func() {
+		close(cl.closing)
+	})
+	timer := time.NewTimer(cl.opts.linger)
+	defer timer.Stop()
+	select {
+	case <-cl.done:
+	case <-timer.C:
+		cl.cancel()
+		timer.Reset(cl.opts.linger)
+		select {
+		case <-cl.done:
+		case <-timer.C:
+		}
+	}
+
+	cl.errMu.Lock()
+	defer cl.errMu.Unlock()
+	return cl.first
+}
+
+// Inform the server that the client opened by the package level Connect is ready to play.
+// See Client.Ready for details.
+func Ready() error {
+	if defaultClient == nil {
+		return errors.New("aisandbox: Ready called before Connect")
+	}
+	return defaultClient.Ready()
+}
+
+// Closes the session opened by the package level Connect, see Client.Close for details.
+func Close() error {
+	if defaultClient == nil {
+		return nil
+	}
+	return defaultClient.Close()
+}
+
+// Errors noticed by the client opened by the package level Connect.
+// See Client.Errors for details, the channel is closed already before Connect.
+func Errors() <-chan error {
+	if defaultClient == nil {
+		return NewClient("").Errors()
+	}
+	return defaultClient.Errors()
 }
 
 // Opens a connection to the server
 // NOTE: In case of shutdown the "in" -channel will be closed to inform commander that it should shut down.
 // NOTE: Close "out" channel when finished to close the connection to the server.
+// NOTE: Use NewClient instead if you need more than one connection in the same process.
 // Params:
 // host, port - address and port of the server
 // name - name of the commander
@@ -213,22 +832,27 @@ func Ready() {
 // in - incoming updates, being either LevelInfo or GameInfo structs (possibly add control struct to inform about Shutdown etc)
 // out - outgoing channel where commander can send his commands, preferably Defend, Attack, Move or Charge structs.
 func Connect(host string, port int, name string) (in <-chan interface{}, out chan<- Command, err error) {
-	for start := time.Now(); time.Since(start) < time.Second*10; time.Sleep(time.Millisecond * 500) {
-		conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", host, port))
-		if err == nil {
-			break
-		}
-	}
-	if err != nil {
-		log.Printf("Failed to connect to the server: %s", err.Error())
+	cl := NewClient(name)
+	if err = cl.Connect(host, port); err != nil {
 		return
 	}
+	defaultClient = cl
+	return cl.In(), cl.Out(), nil
+}
 
-	i, o := make(chan interface{}), make(chan Command)
+// Like Connect, but the dial retry policy is set with opts and cancelling ctx ends the session.
+// addr is in "host:port" format.
+func ConnectContext(ctx context.Context, addr string, name string, opts ...Option) (*Client, error) {
+	cl := NewClient(name)
+	if err := cl.ConnectContext(ctx, addr, opts...); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
 
-	in = i
-	out = o
-	go listenForGameData(name, i)
-	go listenForPlayerCommands(o)
-	return
+// Runs a session for commander called name over conn. See Client.Open for details.
+func Open(ctx context.Context, conn io.ReadWriteCloser, name string, opts ...Option) *Client {
+	cl := NewClient(name)
+	cl.Open(ctx, conn, opts...)
+	return cl
 }
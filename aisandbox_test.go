@@ -0,0 +1,1168 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const json_connect = `<connect>
+{"__class__": "ConnectServer", "__value__": {"protocolVersion": "1.4"}}
+`
+
+// Plays the server side of a single match: handshake, initialize, one tick and shutdown.
+// Returns the commander name the client introduced itself with.
+func serveMatch(t *testing.T, conn net.Conn) string {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte(json_connect))
+	frame, err := ReadFrame(r)
+	if err != nil || frame.Header != "<connect>" {
+		t.Errorf("Expected <connect>, got %q (%v)", frame.Raw, err)
+		return ""
+	}
+	reply := new(json_ClientConnect)
+	if err := json.Unmarshal(frame.Payload[0], &reply); err != nil {
+		t.Errorf(err.Error())
+		return ""
+	}
+
+	conn.Write([]byte(json_init))
+	if line, _ := r.ReadString('\n'); strings.TrimSpace(line) != "<ready>" {
+		t.Errorf("Expected <ready>, got %q", line)
+	}
+	conn.Write([]byte(json_tick))
+	conn.Write([]byte(json_shutdown))
+	return reply.Value.CommanderName
+}
+
+// Plays the server side of handshake and initialization without checking what the client sends.
+// Returns the reader for the rest of the frames from the client.
+func serveInit(conn net.Conn) *bufio.Reader {
+	r := bufio.NewReader(conn)
+	conn.Write([]byte(json_connect))
+	ReadFrame(r)
+	conn.Write([]byte(json_init))
+	return r
+}
+
+func TestTwoClients(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	names := make(chan string, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { names <- serveMatch(t, conn) }()
+		}
+	}()
+
+	clients := []*Client{NewClient("Alpha"), NewClient("Beta")}
+	for _, cl := range clients {
+		if err := cl.Connect("127.0.0.1", port); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	done := make(chan int, 2)
+	for _, cl := range clients {
+		go func(cl *Client) {
+			var count int
+			for msg := range cl.In() {
+				if _, ok := msg.(*GameInfo); ok && count == 1 {
+					cl.Ready()
+				}
+				count++
+			}
+			close(cl.Out())
+			done <- count
+		}(cl)
+	}
+
+	for i := 0; i < 2; i++ {
+		if count := <-done; count != 3 {
+			t.Errorf("Expected 3 messages, got %d", count)
+		}
+	}
+	seen := map[string]bool{<-names: true, <-names: true}
+	if !seen["Alpha"] || !seen["Beta"] {
+		t.Errorf("Expected both commanders to connect, got %v", seen)
+	}
+}
+
+func TestConnectContextAttempts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	start := time.Now()
+	_, err = ConnectContext(context.Background(), addr, "Nobody", Attempts(3), Backoff(time.Millisecond, time.Millisecond*4))
+	if err == nil {
+		t.Fatalf("Expected dial error")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected to give up after 3 attempts, took %v", time.Since(start))
+	}
+}
+
+func TestReadyNotConnected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	failed := NewClient("Nobody")
+	if err := failed.ConnectContext(context.Background(), addr, Attempts(1)); err == nil {
+		t.Fatalf("Expected dial error")
+	}
+	for _, cl := range []*Client{failed, NewClient("Never")} {
+		result := make(chan error, 1)
+		go func() { result <- cl.Ready() }()
+		select {
+		case err := <-result:
+			if !errors.Is(err, ErrWrite) {
+				t.Errorf("%s: Expected ErrWrite, got %v", cl.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: Ready() blocked on a client that isn't connected", cl.name)
+		}
+	}
+}
+
+func TestNotConnected(t *testing.T) {
+	cl := NewClient("Never")
+	if _, ok := <-cl.Events(); ok {
+		t.Errorf("Expected Events() to be closed")
+	}
+	if _, ok := <-cl.In(); ok {
+		t.Errorf("Expected In() to be closed")
+	}
+	if _, ok := <-cl.Errors(); ok {
+		t.Errorf("Expected Errors() to be closed")
+	}
+	if m := cl.Metrics(); m != nil {
+		t.Errorf("Expected no metrics, got %v", m)
+	}
+
+	connected := defaultClient
+	defer func() { defaultClient = connected }()
+	defaultClient = nil
+	select {
+	case _, ok := <-Errors():
+		if ok {
+			t.Errorf("Expected package level Errors() to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Package level Errors() blocked before Connect")
+	}
+}
+
+func TestConnectContextCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			// Send the handshake and then go silent.
+			conn.Write([]byte(json_connect))
+			defer conn.Close()
+			bufio.NewReader(conn).ReadString(0)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cl, err := ConnectContext(ctx, l.Addr().String(), "Quitter")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cancel()
+
+	select {
+	case _, ok := <-cl.In():
+		if ok {
+			t.Errorf("Expected In() to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Cancelling context didn't end the session")
+	}
+}
+
+func TestSendAfterCancel(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		io.Copy(io.Discard, serveInit(server))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cl := Open(ctx, client, "Quitter")
+	<-cl.In()
+	cancel()
+	for range cl.In() {
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		cl.Out() <- NewMove("Blue0", "", Vec2{1, 1})
+		cl.Out() <- NewMove("Blue0", "", Vec2{2, 2})
+		close(cl.Out())
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected commands after cancel to be dropped, Out() blocked")
+	}
+	waitGoroutines(t, baseline)
+}
+
+// Starts a server that runs serve for the first connection.
+func startServer(t *testing.T, serve func(conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+// Collects everything from the client until it closes its channels.
+func drain(cl *Client) (msgs []interface{}, errs []error) {
+	for msg := range cl.In() {
+		msgs = append(msgs, msg)
+	}
+	for err := range cl.Errors() {
+		errs = append(errs, err)
+	}
+	return
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		hangup bool // server closes the connection after stream
+		kinds  []error
+	}{
+		{"version", "<connect>\n" + `{"__class__": "ConnectServer", "__value__": {"protocolVersion": "0.1"}}` + "\n", false, []error{ErrVersionMismatch}},
+		{"decode", json_connect + "<tick>\n{\"__class__\": \n", false, []error{ErrUnexpected, ErrDecode}},
+		{"unknown", json_connect + "<banana>\n" + json_shutdown, false, []error{ErrUnknownMessage, ErrUnexpected}},
+		{"eof", "", true, []error{ErrEOF}},
+		{"null connect", "<connect>\nnull\n" + json_connect + json_shutdown, false, []error{ErrDecode, ErrUnexpected}},
+		{"null level", json_connect + "<initialize>\n" + `{"__class__": "LevelInfo", "__value__": null}` + "\n" + strings.Split(json_init, "\n")[2] + "\n" + json_tick, false, []error{ErrDecode, ErrUnexpected}},
+	}
+
+	for _, test := range tests {
+		test := test
+		addr := startServer(t, func(conn net.Conn) {
+			conn.Write([]byte(test.stream))
+			if !test.hangup {
+				// Wait for the client to hang up.
+				bufio.NewReader(conn).ReadString(0)
+			}
+		})
+		cl, err := ConnectContext(context.Background(), addr, "Errors")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		go func() {
+			// Give the reader a moment and then hang up if the stream didn't end the session.
+			time.Sleep(time.Millisecond * 200)
+			close(cl.Out())
+		}()
+		_, errs := drain(cl)
+
+		if len(errs) != len(test.kinds) {
+			t.Errorf("%s: Expected %d errors, got %v", test.name, len(test.kinds), errs)
+			continue
+		}
+		for i, err := range errs {
+			if !errors.Is(err, test.kinds[i]) {
+				t.Errorf("%s: Expected %v, got %v", test.name, test.kinds[i], err)
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	addr := startServer(t, func(conn net.Conn) { serveMatch(t, conn) })
+	cl, err := ConnectContext(context.Background(), addr, "Typed")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var got []string
+	for ev := range cl.Events() {
+		switch e := ev.(type) {
+		case *LevelLoaded:
+			got = append(got, "level")
+		case *GameStarted:
+			got = append(got, "started")
+			cl.Ready()
+		case *Tick:
+			got = append(got, "tick")
+		case *Shutdown:
+			got = append(got, e.Reason)
+		case *Disconnected:
+			got = append(got, e.Err.Error())
+		}
+	}
+	close(cl.Out())
+
+	expected := []string{"level", "started", "tick", REASON_SERVER}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	commands := make(chan string, 1)
+	addr := startServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		conn.Write([]byte(strings.Replace(json_connect, "1.4", "1.3", 1)))
+		r.ReadString('\n')
+		r.ReadString('\n')
+		conn.Write([]byte(json_init))
+		r.ReadString('\n')
+		line, _ := r.ReadString('\n')
+		commands <- line
+		conn.Write([]byte(json_shutdown))
+	})
+	cl, err := ConnectContext(context.Background(), addr, "Retro")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Out() <- NewDefend("Blue0", "Old school", []float64{1, 0})
+		}
+	}
+	close(cl.Out())
+
+	if line := <-commands; !strings.Contains(line, `"facingDirection":[1,0]`) {
+		t.Errorf("Expected 1.3 Defend, got %s", line)
+	}
+}
+
+func TestOpenPipe(t *testing.T) {
+	server, client := net.Pipe()
+	names := make(chan string, 1)
+	go func() { names <- serveMatch(t, server) }()
+
+	cl := Open(context.Background(), client, "Piped")
+	var count int
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Ready()
+		}
+		count++
+	}
+	close(cl.Out())
+
+	if name := <-names; name != "Piped" || count != 4 {
+		t.Errorf("Expected Piped with 4 events, got %s with %d", name, count)
+	}
+}
+
+// Like a bot spawned with a pipe as stdin that the runner keeps open without writing to it.
+func TestStdioClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Closing doesn't interrupt reads of stdin on Windows")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer r.Close()
+	defer w.Close()
+	// Blocking like os.Stdin, closing it wouldn't interrupt a Read.
+	r.Fd()
+
+	cl := Open(context.Background(), newStdio(r, io.Discard), "Child", CloseTimeout(time.Millisecond*100))
+	closed := make(chan struct{})
+	go func() {
+		cl.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("Close() blocked on a pending read of stdin")
+	}
+
+	// Only the session's copy was closed.
+	w.Write([]byte("x"))
+	if n, err := r.Read(make([]byte, 1)); n != 1 {
+		t.Errorf("Expected the pipe to stay open, got %v", err)
+	}
+}
+
+func TestUnixDialer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sandbox.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	defer l.Close()
+	names := make(chan string, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			names <- serveMatch(t, conn)
+		}
+	}()
+
+	cl, err := ConnectContext(context.Background(), path, "Local", WithDialer(NetDialer{"unix"}))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for msg := range cl.In() {
+		if _, ok := msg.(*GameInfo); ok {
+			cl.Ready()
+		}
+	}
+	close(cl.Out())
+
+	if name := <-names; name != "Local" {
+		t.Errorf("Expected Local, got %s", name)
+	}
+}
+
+// Counts calls to Write and fails them after limit calls.
+type countingConn struct {
+	net.Conn
+	writes int
+	limit  int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	if c.writes++; c.limit > 0 && c.writes > c.limit {
+		return 0, errors.New("cable cut")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestBatch(t *testing.T) {
+	server, client := net.Pipe()
+	conn := &countingConn{Conn: client}
+	lines := make(chan []string, 1)
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		var got []string
+		for i := 0; i < 6; i++ {
+			line, _ := r.ReadString('\n')
+			got = append(got, strings.TrimSpace(line))
+		}
+		lines <- got
+		server.Write([]byte(json_shutdown))
+	}()
+
+	cl := Open(context.Background(), conn, "Batcher")
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Out() <- Batch{
+				NewMove("Blue0", "", []float64{1, 1}),
+				NewCharge("Blue1", "", []float64{2, 2}),
+				NewAttack("Blue2", "", nil, []float64{3, 3}),
+			}
+		}
+	}
+	close(cl.Out())
+
+	got := <-lines
+	for i, header := range []string{"<command>", "<command>", "<command>"} {
+		if got[i*2] != header || !strings.HasPrefix(got[i*2+1], "{") {
+			t.Errorf("Expected %s followed by JSON, got %v", header, got[i*2:i*2+2])
+		}
+	}
+	// Handshake and the batch
+	if conn.writes != 2 {
+		t.Errorf("Expected 2 writes, got %d", conn.writes)
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		serveInit(server).ReadString(0)
+	}()
+
+	cl := Open(context.Background(), &countingConn{Conn: client, limit: 1}, "Unlucky")
+	var final Event
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+		}
+		final = ev
+	}
+
+	if d, ok := final.(*Disconnected); !ok || !errors.Is(d.Err, ErrWrite) {
+		t.Errorf("Expected Disconnected with ErrWrite, got %#v", final)
+	}
+}
+
+func TestEncodeFailure(t *testing.T) {
+	server, client := net.Pipe()
+	lines := make(chan []string, 1)
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		header, _ := r.ReadString('\n')
+		body, _ := r.ReadString('\n')
+		lines <- []string{strings.TrimSpace(header), strings.TrimSpace(body)}
+		server.Write([]byte(json_shutdown))
+	}()
+
+	cl := Open(context.Background(), client, "Clumsy")
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Out() <- NewMove("Blue0", "", Vec2{1, 2, 3})
+			cl.Out() <- rawCommand(nil)
+			cl.Out() <- NewMove("Blue1", "", Vec2{1, 2})
+		}
+	}
+	close(cl.Out())
+
+	if got := <-lines; got[0] != "<command>" || !strings.Contains(got[1], `"Blue1"`) {
+		t.Errorf("Expected the command of Blue1 to be sent, got %v", got)
+	}
+	if err := <-cl.Errors(); !errors.Is(err, ErrEncode) {
+		t.Errorf("Expected ErrEncode, got %v", err)
+	}
+	if err := cl.Close(); errors.Is(err, ErrWrite) {
+		t.Errorf("Expected the session to continue, got %v", err)
+	}
+}
+
+func TestLatencyBudget(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		server.Write([]byte(json_tick))
+		r.ReadString('\n')
+		r.ReadString('\n')
+		server.Write([]byte(json_shutdown))
+	}()
+
+	cl := Open(context.Background(), client, "Slowpoke", Budget(time.Millisecond))
+	var warnings int
+	for ev := range cl.Events() {
+		switch e := ev.(type) {
+		case *Tick:
+			time.Sleep(time.Millisecond * 5)
+			cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+		case *BudgetExceeded:
+			if e.Latency < time.Millisecond*5 {
+				t.Errorf("Expected latency of at least 5ms, got %v", e.Latency)
+			}
+			warnings++
+		}
+	}
+	close(cl.Out())
+
+	stats := cl.Latency()
+	if warnings != 1 || stats.Ticks != 1 {
+		t.Errorf("Expected 1 warning for 1 tick, got %d for %d", warnings, stats.Ticks)
+	}
+	var slow int
+	for _, bucket := range stats.Histogram[3:] {
+		slow += bucket.Count
+	}
+	if slow != 1 {
+		t.Errorf("Expected latency above 5ms bucket, got %v", stats.Histogram)
+	}
+}
+
+func TestLatencyLagging(t *testing.T) {
+	// All ticks are sent at once, so the commander falls behind and the reader reads
+	// the next tick long before the commander answers the previous one.
+	const ticks = 4
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		go func() {
+			for i := 0; i < ticks; i++ {
+				server.Write([]byte(json_tick))
+			}
+		}()
+		for commands := 0; commands < ticks; {
+			frame, err := ReadFrame(r)
+			if err != nil {
+				return
+			}
+			if frame.Header == "<command>" {
+				commands++
+			}
+		}
+		server.Write([]byte(json_shutdown))
+		io.Copy(io.Discard, r)
+	}()
+
+	cl := Open(context.Background(), client, "Laggard", Budget(time.Millisecond*50))
+	var warnings int
+	for ev := range cl.Events() {
+		switch ev.(type) {
+		case *Tick:
+			time.Sleep(time.Millisecond * 60)
+			cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+		case *BudgetExceeded:
+			warnings++
+		}
+	}
+	close(cl.Out())
+
+	// Every tick but the first waited for the commander to answer the previous one.
+	stats := cl.Latency()
+	if stats.Ticks != ticks || warnings != ticks {
+		t.Errorf("Expected %d ticks over budget, got %d warnings for %d ticks", ticks, warnings, stats.Ticks)
+	}
+	if stats.Max < time.Millisecond*100 {
+		t.Errorf("Expected the lag to count towards latency, got max %v", stats.Max)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	server, client := net.Pipe()
+	go serveMatch(t, server)
+
+	var recording bytes.Buffer
+	cl := Open(context.Background(), client, "Recorded", Record(&recording))
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Ready()
+		}
+	}
+	close(cl.Out())
+
+	var (
+		received []byte
+		frames   []string
+	)
+	dec := json.NewDecoder(&recording)
+	for {
+		entry := new(RecordEntry)
+		if err := dec.Decode(entry); err != nil {
+			break
+		}
+		frames = append(frames, entry.Dir+entry.Frame)
+		if entry.Dir == DIR_RECV {
+			received = append(received, entry.Bytes()...)
+		}
+	}
+
+	expected := []string{"recv<connect>", "send<connect>", "recv<initialize>", "send<ready>", "recv<tick>", "recv<shutdown>"}
+	if fmt.Sprint(frames) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, frames)
+	}
+	// json_shutdown has a trailing space that is never read since <shutdown> ends the session.
+	stream := json_connect + json_init + json_tick + strings.TrimSuffix(json_shutdown, " ")
+	if string(received) != stream {
+		t.Errorf("Recorded stream differs from what the server sent")
+	}
+}
+
+func TestNewRecordEntry(t *testing.T) {
+	for _, raw := range []string{"<tick>\n{}\n", "<tick>\n\"\xff\"\n"} {
+		entry := NewRecordEntry(DIR_RECV, &Frame{Header: "<tick>", Raw: []byte(raw)}, 3)
+		if string(entry.Bytes()) != raw || entry.Frame != "<tick>" || entry.Tick != 3 || (entry.Raw64 != nil) == utf8.ValidString(raw) {
+			t.Errorf("%q: Expected the frame back, Raw64 only for invalid UTF-8, got %+v", raw, entry)
+		}
+	}
+}
+
+// Server that floods ticks until the connection is closed and reports the frames it reads.
+func serveFlood(conn net.Conn, prefix string) <-chan string {
+	headers := make(chan string, 16)
+	go func() {
+		conn.Write([]byte(json_connect + prefix + json_init))
+		for {
+			if _, err := conn.Write([]byte(json_tick)); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(headers)
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := ReadFrame(r)
+			if err != nil {
+				return
+			}
+			select {
+			case headers <- frame.Header:
+			default:
+			}
+		}
+	}()
+	return headers
+}
+
+// Waits a while for the goroutines of finished sessions to stop.
+func waitGoroutines(t *testing.T, baseline int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestClose(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	// Commander stops reading in the middle of a flood of ticks.
+	server, client := net.Pipe()
+	headers := serveFlood(server, "")
+	cl := Open(context.Background(), client, "Quitter")
+	<-cl.In()
+	cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+	if err := cl.Close(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, ok := <-cl.Errors(); ok {
+		t.Errorf("Expected Errors() to be closed")
+	}
+	var got []string
+	for header := range headers {
+		got = append(got, header)
+	}
+	if len(got) != 2 || got[1] != "<command>" {
+		t.Errorf("Expected the pending command to be written before closing, got %v", got)
+	}
+	close(cl.Out())
+	waitGoroutines(t, baseline)
+
+	// Commander that keeps sending after Close.
+	server, client = net.Pipe()
+	serveFlood(server, "")
+	cl = Open(context.Background(), client, "Quitter")
+	<-cl.In()
+	cl.Close()
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < 3; i++ {
+			cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+		}
+		close(cl.Out())
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Errorf("Expected commands after Close to be dropped, Out() blocked")
+	}
+	waitGoroutines(t, baseline)
+
+	// Close returns the first error of the session, even if nobody read Errors().
+	server, client = net.Pipe()
+	serveFlood(server, "<banana>\n")
+	cl = Open(context.Background(), client, "Quitter")
+	for range cl.Events() {
+		if err := cl.Close(); !errors.Is(err, ErrUnknownMessage) {
+			t.Errorf("Expected ErrUnknownMessage, got %v", err)
+		}
+	}
+	close(cl.Out())
+	waitGoroutines(t, baseline)
+
+	// Commander that is stuck sending to a server that doesn't read.
+	server, client = net.Pipe()
+	cl = Open(context.Background(), client, "Quitter", CloseTimeout(time.Millisecond*50))
+	go func() {
+		cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+		close(cl.Out())
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cl.Close()
+	server.Close()
+	waitGoroutines(t, baseline)
+
+	// Connection whose Close doesn't interrupt the pending read.
+	stuck := stuckReader{make(chan struct{})}
+	cl = Open(context.Background(), stuck, "Quitter", CloseTimeout(time.Millisecond*50))
+	closed := make(chan struct{})
+	go func() {
+		cl.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("Close waited for a read that can't be interrupted")
+	}
+	close(stuck.release)
+	for range cl.Events() {
+	}
+	close(cl.Out())
+	waitGoroutines(t, baseline)
+}
+
+// Connection that blocks reads until release is closed, no matter if it's closed itself.
+type stuckReader struct {
+	release chan struct{}
+}
+
+func (r stuckReader) Read(p []byte) (int, error) {
+	<-r.release
+	return 0, io.EOF
+}
+
+func (r stuckReader) Write(p []byte) (int, error) { return len(p), nil }
+func (r stuckReader) Close() error                { return nil }
+
+func TestConflate(t *testing.T) {
+	// Ticks with one combat event each, at times 1..5
+	events := regexp.MustCompile(`"combatEvents": \[.*\], "timePassed"`)
+	tick := func(i int) string {
+		return events.ReplaceAllString(json_tick, fmt.Sprintf(`"combatEvents": [{"__class__": "MatchCombatEvent", "__value__": {"instigator": "Blue0", "time": %d, "type": 1, "subject": "Red%d"}}], "timePassed"`, i, i))
+	}
+	sent := make(chan struct{})
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		go io.Copy(io.Discard, serveInit(server))
+		for i := 1; i <= 5; i++ {
+			server.Write([]byte(tick(i)))
+		}
+		// Once <shutdown> is read, every tick has been passed on.
+		server.Write([]byte(json_shutdown))
+		close(sent)
+	}()
+
+	cl := Open(context.Background(), client, "Slowpoke", Conflate())
+	var ticks []*Tick
+	for ev := range cl.Events() {
+		switch e := ev.(type) {
+		case *GameStarted:
+			// Too slow to keep up with any of the ticks.
+			<-sent
+		case *Tick:
+			ticks = append(ticks, e)
+		}
+	}
+	cl.Close()
+
+	if len(ticks) != 1 || ticks[0].Dropped != 4 || cl.Dropped() != 4 {
+		t.Fatalf("Expected a single tick with 4 dropped, got %d ticks and %d dropped", len(ticks), cl.Dropped())
+	}
+	combat := ticks[0].Game.Match.CombatEvents
+	if len(combat) != 5 || combat[0].Subject != "Red1" || combat[4].Subject != "Red5" {
+		t.Errorf("Expected combat events of all 5 ticks in order, got %d", len(combat))
+	}
+}
+
+func TestAutoReady(t *testing.T) {
+	// Server that starts the game at the first <ready>, or right away if impatient.
+	// Commanders get 10 seconds for initialization.
+	init := strings.Replace(json_init, `"runningSpeed"`, `"initializationTime": 10.0, "runningSpeed"`, 1)
+	serve := func(conn net.Conn, impatient bool, inits int) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte(json_connect))
+		ReadFrame(r)
+		conn.Write([]byte(strings.Repeat(init, inits)))
+		for !impatient {
+			if frame, err := ReadFrame(r); err != nil || frame.Header == "<ready>" {
+				break
+			}
+		}
+		conn.Write([]byte(json_tick + json_shutdown))
+		io.Copy(io.Discard, conn)
+	}
+	events := func(cl *Client) (names []string) {
+		for ev := range cl.Events() {
+			names = append(names, fmt.Sprintf("%T", ev))
+		}
+		cl.Close()
+		return
+	}
+
+	server, client := net.Pipe()
+	go serve(server, false, 1)
+	got := events(Open(context.Background(), client, "Sleepy", AutoReady(time.Second*10-time.Millisecond*50)))
+	if strings.Join(got, " ") != "*aisandbox.LevelLoaded *aisandbox.GameStarted *aisandbox.AutoReadySent *aisandbox.Tick *aisandbox.Shutdown" {
+		t.Errorf("Expected AutoReadySent before the tick, got %v", got)
+	}
+
+	server, client = net.Pipe()
+	go serve(server, true, 1)
+	got = events(Open(context.Background(), client, "Sleepy"))
+	if strings.Join(got, " ") != "*aisandbox.LevelLoaded *aisandbox.GameStarted *aisandbox.ReadyMissed *aisandbox.Tick *aisandbox.Shutdown" {
+		t.Errorf("Expected ReadyMissed before the tick, got %v", got)
+	}
+
+	// A second <initialize> is reported and ignored, it doesn't start another AutoReady() timer.
+	server, client = net.Pipe()
+	go serve(server, false, 2)
+	cl := Open(context.Background(), client, "Sleepy", AutoReady(time.Second*10-time.Millisecond*50))
+	got = events(cl)
+	if strings.Join(got, " ") != "*aisandbox.LevelLoaded *aisandbox.GameStarted *aisandbox.AutoReadySent *aisandbox.Tick *aisandbox.Shutdown" {
+		t.Errorf("Expected the second <initialize> to be ignored, got %v", got)
+	}
+	if err := cl.Close(); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("Expected ErrUnexpected, got %v", err)
+	}
+}
+
+func TestReadyOrder(t *testing.T) {
+	server, client := net.Pipe()
+	headers := make(chan []string, 1)
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		var got []string
+		for len(got) < 3 {
+			frame, err := ReadFrame(r)
+			if err != nil {
+				break
+			}
+			got = append(got, frame.Header)
+		}
+		headers <- got
+		server.Write([]byte(json_shutdown))
+	}()
+
+	cl := Open(context.Background(), client, "Orderly")
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+			cl.Out() <- NewMove("Blue1", "", []float64{1, 1})
+			if err := cl.Ready(); err != nil {
+				t.Errorf(err.Error())
+			}
+		}
+	}
+	cl.Close()
+
+	if got := strings.Join(<-headers, " "); got != "<command> <command> <ready>" {
+		t.Errorf("Expected commands sent before Ready() to be written first, got %s", got)
+	}
+}
+
+func TestStall(t *testing.T) {
+	// Server that goes silent after sending frames, without closing the connection.
+	silent := func(frames string) net.Conn {
+		server, client := net.Pipe()
+		go io.Copy(io.Discard, server)
+		go server.Write([]byte(frames))
+		t.Cleanup(func() { server.Close() })
+		return client
+	}
+
+	cl := Open(context.Background(), silent(json_connect+json_init+json_tick), "Patient", StallTimeout(time.Millisecond*20, time.Second))
+	for ev := range cl.Events() {
+		switch e := ev.(type) {
+		case *GameStarted:
+			cl.Ready()
+		case *Stalled:
+			if e.Initializing || e.Silence < time.Millisecond*20 {
+				t.Errorf("Expected a stall after the tick, got %+v", e)
+			}
+			cl.Close()
+		}
+	}
+	if err := cl.Close(); err != nil {
+		t.Errorf("Expected the session to go on after a stall, got %v", err)
+	}
+
+	cl = Open(context.Background(), silent(json_connect+json_init), "Impatient", StallTimeout(time.Second, time.Millisecond*50), AbortOnStall())
+	var got []string
+	for ev := range cl.Events() {
+		got = append(got, fmt.Sprintf("%T", ev))
+		switch e := ev.(type) {
+		case *Stalled:
+			if !e.Initializing {
+				t.Errorf("Expected a stall during initialization, got %+v", e)
+			}
+		case *Disconnected:
+			if !errors.Is(e.Err, ErrStalled) {
+				t.Errorf("Expected ErrStalled, got %v", e.Err)
+			}
+		}
+	}
+	if strings.Join(got, " ") != "*aisandbox.LevelLoaded *aisandbox.GameStarted *aisandbox.Stalled *aisandbox.Disconnected" {
+		t.Errorf("Expected Stalled and Disconnected after initialization, got %v", got)
+	}
+	cl.Close()
+}
+
+func TestStallWriterBlocked(t *testing.T) {
+	// Server that stops reading after initialization, so the writer gets stuck on the commands.
+	server, client := net.Pipe()
+	defer server.Close()
+	go serveInit(server)
+
+	cl := Open(context.Background(), client, "Stuck", StallTimeout(time.Millisecond*50, time.Millisecond*50), AbortOnStall())
+	defer cl.Close()
+	done := make(chan []string)
+	go func() {
+		var got []string
+		for ev := range cl.Events() {
+			switch ev.(type) {
+			case *GameStarted:
+				for i := 0; i < 3; i++ {
+					cl.Out() <- NewMove("Blue0", "", []float64{1, 1})
+				}
+			case *Stalled, *Disconnected:
+				got = append(got, fmt.Sprintf("%T", ev))
+			}
+		}
+		done <- got
+	}()
+
+	select {
+	case got := <-done:
+		if strings.Join(got, " ") != "*aisandbox.Stalled *aisandbox.Disconnected" {
+			t.Errorf("Expected Stalled and Disconnected, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Stall not detected while the writer was blocked")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server, client := net.Pipe()
+	go serveMatch(t, server)
+	cl := Open(context.Background(), client, "Measured", Metrics("aisandbox-test"))
+	for ev := range cl.Events() {
+		switch ev.(type) {
+		case *GameStarted:
+			cl.Ready()
+		case *Tick:
+			cl.Out() <- Batch{NewMove("Blue0", "", []float64{1, 1}), NewMove("Blue1", "", []float64{1, 1})}
+		}
+	}
+	cl.Close()
+
+	var vars struct {
+		FramesReceived map[string]int
+		CommandsSent   map[string]int
+		BytesRead      int
+		BytesWritten   int
+		LastTimePassed float64
+		Backlog        int
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("aisandbox-test").String()), &vars); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if vars.FramesReceived["<tick>"] != 1 || vars.FramesReceived["<initialize>"] != 1 || vars.CommandsSent["Move"] != 2 {
+		t.Errorf("Expected 1 tick, 1 initialize and 2 moves, got %+v", vars)
+	}
+	if vars.BytesRead < len(json_tick) || vars.BytesWritten == 0 || vars.LastTimePassed == 0 || vars.Backlog != 0 {
+		t.Errorf("Expected bytes both ways, TimePassed of the tick and no backlog, got %+v", vars)
+	}
+
+	// A new session takes over the name.
+	server, client = net.Pipe()
+	server.Close()
+	cl = Open(context.Background(), client, "Measured", Metrics("aisandbox-test"))
+	cl.Close()
+	if s := expvar.Get("aisandbox-test").String(); strings.Contains(s, "<tick>") {
+		t.Errorf("Expected the metrics of the new session, got %s", s)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	server, client := net.Pipe()
+	go serveMatch(t, server)
+	cl := Open(context.Background(), client, "Logged", Logger(logger))
+	for ev := range cl.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			cl.Ready()
+		}
+	}
+	cl.Close()
+
+	records := make(map[string]map[string]interface{})
+	for _, line := range bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n")) {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if record["session"] != "Logged" {
+			t.Errorf("Expected session in every record, got %s", line)
+		}
+		records[record["msg"].(string)] = record
+	}
+	if connected := records["Connected"]; connected == nil || connected["frame"] != "<connect>" || connected["version"] == nil {
+		t.Errorf("Expected handshake record with version, got %v", connected)
+	}
+	if ended := records["Session ended"]; ended == nil || ended["frame"] != "<shutdown>" || ended["gameTime"] == 0.0 {
+		t.Errorf("Expected end of the session with game time, got %v", ended)
+	}
+}
+
+// Without Logger() nothing is formatted, whatever the level.
+func TestQuiet(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelError, slog.LevelError + 100} {
+		if quiet.Enabled(context.Background(), level) {
+			t.Errorf("Expected the default logger to be disabled at %v", level)
+		}
+	}
+}
+
+// Command.JSON() has no session to report to, it must not log to the default logger either.
+func TestCommandJSONQuiet(t *testing.T) {
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	if b := NewMove("Blue0", "", Vec2{1, 2, 3}).JSON(); b != nil || logged.Len() > 0 {
+		t.Errorf("Expected nil without logging, got %q and %q", b, logged.String())
+	}
+}
@@ -0,0 +1,51 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+// Command aisandbox-proxy sits between bots and the game server and prints every frame
+// passing through, in both directions. Bots connect to the proxy instead of the server:
+//
+//	aisandbox-proxy -listen :41042 -server localhost:41041 -pretty
+//
+// Frames can also be rewritten on the way, eg. -drop Attack,Charge drops those commands
+// and -delay 100ms holds every tick before passing it to the bot.
+// With -record dir each session is written to dir/session-N.jsonl, which aisandbox.Replay can read.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+func main() {
+	var (
+		p = &proxy{
+			log: log.New(os.Stdout, "", log.Ltime|log.Lmicroseconds),
+		}
+		listen = flag.String("listen", ":41042", "address the bots connect to")
+		drop   = flag.String("drop", "", "comma separated command classes to drop, eg. Attack,Charge. * drops all")
+		delay  = flag.Duration("delay", 0, "delay every <tick> by this long before passing it to the bot")
+	)
+	flag.StringVar(&p.server, "server", "localhost:41041", "address of the game server")
+	flag.BoolVar(&p.pretty, "pretty", false, "indent JSON payloads")
+	flag.BoolVar(&p.quiet, "quiet", false, "don't print frames")
+	flag.StringVar(&p.record, "record", "", "directory to record sessions into")
+	flag.Parse()
+
+	if *drop != "" {
+		p.hooks = append(p.hooks, DropCommands(strings.Split(*drop, ",")...))
+	}
+	if *delay > 0 {
+		p.hooks = append(p.hooks, DelayTicks(*delay))
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Forwarding %s to %s", l.Addr(), p.server)
+	log.Fatalln(p.serve(l))
+}
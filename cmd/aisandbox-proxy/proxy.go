@@ -0,0 +1,204 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/errnoh/aisandbox"
+)
+
+// NOTE: This file contains the forwarding, logging and rewriting of frames.
+
+// Hook rewrites a frame on its way through the proxy.
+// dir is aisandbox.DIR_RECV for frames from the server and aisandbox.DIR_SEND for frames from the bot.
+// Returns the frame to forward instead of f, or nil to drop it.
+type Hook func(dir string, f *aisandbox.Frame) *aisandbox.Frame
+
+// Drops every command whose __class__ is in classes, "*" drops all of them.
+func DropCommands(classes ...string) Hook {
+	drop := make(map[string]bool)
+	for _, class := range classes {
+		drop[class] = true
+	}
+	return func(dir string, f *aisandbox.Frame) *aisandbox.Frame {
+		if dir != aisandbox.DIR_SEND || f.Header != "<command>" || len(f.Payload) != 1 {
+			return f
+		}
+		var cmd struct {
+			Class string `json:"__class__"`
+		}
+		json.Unmarshal(f.Payload[0], &cmd)
+		if drop["*"] || drop[cmd.Class] {
+			return nil
+		}
+		return f
+	}
+}
+
+// Holds every <tick> for d before passing it to the bot, eg. to test how a bot copes with lag.
+func DelayTicks(d time.Duration) Hook {
+	return func(dir string, f *aisandbox.Frame) *aisandbox.Frame {
+		if dir == aisandbox.DIR_RECV && f.Header == "<tick>" {
+			time.Sleep(d)
+		}
+		return f
+	}
+}
+
+type proxy struct {
+	server string // address of the real server
+	hooks  []Hook
+	pretty bool   // indent JSON payloads instead of printing them as they are
+	quiet  bool   // don't print frames at all
+	record string // directory for per-session recordings, empty for none
+	log    *log.Logger
+
+	sessions int64
+}
+
+// One bot connected through the proxy.
+type session struct {
+	id    int64
+	ticks atomic.Int64 // GameInfos forwarded to the bot, used to stamp recorded frames
+
+	mu  sync.Mutex
+	enc *json.Encoder // nil if not recording
+}
+
+// Accepts bots on l until it's closed.
+func (p *proxy) serve(l net.Listener) error {
+	for {
+		bot, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(bot)
+	}
+}
+
+// Connects bot to the server and forwards frames both ways until either side closes.
+func (p *proxy) handle(bot net.Conn) {
+	s := &session{id: atomic.AddInt64(&p.sessions, 1)}
+
+	server, err := net.Dial("tcp", p.server)
+	if err != nil {
+		p.log.Printf("session %d: %s", s.id, err)
+		bot.Close()
+		return
+	}
+	p.log.Printf("session %d: %s <-> %s", s.id, bot.RemoteAddr(), p.server)
+
+	if p.record != "" {
+		f, err := os.Create(filepath.Join(p.record, fmt.Sprintf("session-%d.jsonl", s.id)))
+		if err != nil {
+			p.log.Printf("session %d: not recording: %s", s.id, err)
+		} else {
+			defer f.Close()
+			s.enc = json.NewEncoder(f)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(s, aisandbox.DIR_RECV, server, bot)
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(s, aisandbox.DIR_SEND, bot, server)
+	}()
+	wg.Wait()
+	p.log.Printf("session %d: closed", s.id)
+}
+
+// Reads frames from src, prints and rewrites them and writes them to dst.
+// Closes both connections when done, which also stops the other direction.
+func (p *proxy) forward(s *session, dir string, src, dst net.Conn) {
+	defer src.Close()
+	defer dst.Close()
+
+	r := bufio.NewReader(src)
+	for {
+		f, err := aisandbox.ReadFrame(r)
+		if err != nil {
+			// Pass on whatever was read, the other side sees the same broken frame.
+			if len(f.Raw) > 0 {
+				dst.Write(f.Raw)
+			}
+			if err != io.EOF {
+				p.log.Printf("session %d: %s", s.id, err)
+			}
+			return
+		}
+
+		p.print(s, dir, f)
+		for _, hook := range p.hooks {
+			if f = hook(dir, f); f == nil {
+				break
+			}
+		}
+		if f == nil {
+			if !p.quiet {
+				p.log.Printf("session %d: dropped", s.id)
+			}
+			continue
+		}
+		if dir == aisandbox.DIR_RECV && (f.Header == "<initialize>" || f.Header == "<tick>") {
+			s.ticks.Add(1)
+		}
+		s.recordFrame(dir, f)
+
+		if _, err := dst.Write(f.Raw); err != nil {
+			p.log.Printf("session %d: %s", s.id, err)
+			return
+		}
+	}
+}
+
+// Prints f as "session 1 bot -> server <command>" followed by its payload.
+func (p *proxy) print(s *session, dir string, f *aisandbox.Frame) {
+	if p.quiet {
+		return
+	}
+	arrow := "server -> bot"
+	if dir == aisandbox.DIR_SEND {
+		arrow = "bot -> server"
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "session %d %s %s", s.id, arrow, f.Header)
+	for _, payload := range f.Payload {
+		buffer.WriteString("\n    ")
+		if !p.pretty || json.Indent(&buffer, bytes.TrimSpace(payload), "    ", "  ") != nil {
+			buffer.Write(bytes.TrimSpace(payload))
+		}
+	}
+	p.log.Print(buffer.String())
+}
+
+// Writes f into the session's recording in the format of aisandbox.Record(), so it can be replayed.
+func (s *session) recordFrame(dir string, f *aisandbox.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enc == nil {
+		return
+	}
+	if err := s.enc.Encode(aisandbox.NewRecordEntry(dir, f, int(s.ticks.Load())-1)); err != nil {
+		s.enc = nil
+	}
+}
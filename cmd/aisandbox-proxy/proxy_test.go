@@ -0,0 +1,77 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/errnoh/aisandbox"
+)
+
+func TestProxyDropCommands(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer server.Close()
+	received := make(chan *aisandbox.Frame)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(aisandbox.NewFrame("<tick>", []byte(`{}`)).Raw)
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := aisandbox.ReadFrame(r)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- frame
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer l.Close()
+	p := &proxy{
+		server: server.Addr().String(),
+		hooks:  []Hook{DropCommands("Attack")},
+		pretty: true,
+		log:    log.New(io.Discard, "", 0),
+	}
+	go p.serve(l)
+
+	bot, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if frame, err := aisandbox.ReadFrame(bufio.NewReader(bot)); err != nil || frame.Header != "<tick>" {
+		t.Fatalf("Expected <tick> through the proxy, got %v %v", frame, err)
+	}
+	bot.Write(aisandbox.NewFrame("<command>", []byte(`{"__class__": "Attack", "__value__": {}}`)).Raw)
+	bot.Write(aisandbox.NewFrame("<command>", []byte(`{"__class__": "Move", "__value__": {}}`)).Raw)
+	bot.Write(aisandbox.NewFrame("<ready>").Raw)
+	bot.Close()
+
+	var headers []string
+	for frame := range received {
+		headers = append(headers, frame.Header)
+		if frame.Header == "<command>" && string(frame.Payload[0]) != "{\"__class__\": \"Move\", \"__value__\": {}}\n" {
+			t.Errorf("Expected only Move to get through, got %s", frame.Payload[0])
+		}
+	}
+	if len(headers) != 2 || headers[1] != "<ready>" {
+		t.Errorf("Expected <command> and <ready>, got %v", headers)
+	}
+}
@@ -0,0 +1,65 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"sort"
+	"sync"
+)
+
+// NOTE: This file contains the codec registry used to pick the protocol version at handshake.
+
+// Codec handles everything that differs between protocol versions:
+// the JSON structs sent by the server, simplifying them and serializing commands.
+type Codec interface {
+	// Protocol version as sent by the server in <connect>, eg. "1.4"
+	Version() string
+	// Parses the LevelInfo payload of <initialize>
+	DecodeLevelInfo(b []byte) (*LevelInfo, error)
+	// Parses the GameInfo payload of <initialize> and <tick>.
+	// If the GameInfo is usable but incomplete, it's returned along with an error of type Inconsistencies.
+	DecodeGameInfo(b []byte) (*GameInfo, error)
+	// Serializes cmd into a single line of JSON, including the trailing newline
+	EncodeCommand(cmd Command) ([]byte, error)
+}
+
+var (
+	codecMu sync.RWMutex
+	codecs  = make(map[string]Codec)
+)
+
+// NOTE: There's no codec for 1.2. Without a 1.2 server or recording at hand its wire format
+// can't be checked, and a guessed codec would fail in ways that are hard to notice.
+// Once the format is known, a codec for it can be registered with RegisterCodec.
+func init() {
+	RegisterCodec(codec14{})
+	RegisterCodec(codec13{})
+}
+
+// Makes c available for the handshake. Registering the same version twice replaces the old codec.
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.Version()] = c
+}
+
+// Returns the codec registered for version, or nil.
+func LookupCodec(version string) Codec {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return codecs[version]
+}
+
+// Versions of all registered codecs, sorted.
+func Versions() []string {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	versions := make([]string, 0, len(codecs))
+	for v := range codecs {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
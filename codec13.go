@@ -0,0 +1,78 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// NOTE: This file contains the codec for protocol version 1.3.
+// Of the messages these bindings handle only Defend differs from 1.4:
+// 1.3 has a single facing direction instead of a list of [direction, duration] pairs.
+
+// The LevelInfo and GameInfo payloads of 1.3 have the same fields as in 1.4.
+// They're still types of their own so that a difference found later is handled here, not in the 1.4 structs.
+// That's also why ReuseGameInfo() doesn't apply to 1.3, its decoder works on the 1.4 structs.
+type json13_LevelInfo json_LevelInfo
+
+type json13_GameInfo json_GameInfo
+
+func (data *json13_GameInfo) simplify() (*GameInfo, error) {
+	return (*json_GameInfo)(data).simplify()
+}
+
+type json13_Defend struct {
+	Bot             string    `json:"bot"`
+	FacingDirection []float64 `json:"facingDirection"` // optional, null lets the bot face any direction
+	Description     string    `json:"description"`
+}
+
+type codec13 struct{}
+
+func (codec13) Version() string {
+	return "1.3"
+}
+
+func (codec13) DecodeLevelInfo(b []byte) (*LevelInfo, error) {
+	li := new(json13_LevelInfo)
+	if err := json.Unmarshal(b, li); err != nil {
+		return nil, err
+	}
+	if li.Value == nil {
+		return nil, errors.New("LevelInfo missing")
+	}
+	return li.Value, nil
+}
+
+func (codec13) DecodeGameInfo(b []byte) (*GameInfo, error) {
+	gi := new(json13_GameInfo)
+	if err := json.Unmarshal(b, gi); err != nil {
+		return nil, err
+	}
+	return gi.simplify()
+}
+
+// Defend is sent with the first of its FacingDirections, durations are dropped.
+func (codec13) EncodeCommand(cmd Command) ([]byte, error) {
+	defend, ok := cmd.(*Defend)
+	if !ok {
+		return codec14{}.EncodeCommand(cmd)
+	}
+
+	value := &json13_Defend{
+		Bot:         defend.Bot,
+		Description: defend.Description,
+	}
+	if len(defend.FacingDirections) > 0 {
+		value.FacingDirection = defend.FacingDirections[0].Direction
+	}
+
+	b, err := json.Marshal(json_Command{Class: "Defend", Value: value})
+	if err != nil {
+		return nil, err
+	}
+	return trim(b), nil
+}
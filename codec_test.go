@@ -0,0 +1,79 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"testing"
+)
+
+func TestCodecDefend(t *testing.T) {
+	cmd := NewDefend("Blue0", "Stare", []float64{1, 2, 3}, []float64{4, 5})
+
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"1.4", `{"__class__":"Defend","__value__":{"bot":"Blue0","facingDirections":[[[1.000000,2.000000],3.000000],[[4.000000,5.000000],0.000000]],"description":"Stare"}}` + "\n"},
+		{"1.3", `{"__class__":"Defend","__value__":{"bot":"Blue0","facingDirection":[1,2],"description":"Stare"}}` + "\n"},
+	}
+
+	for _, test := range tests {
+		b, err := LookupCodec(test.version).EncodeCommand(cmd)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if string(b) != test.expected {
+			t.Errorf("%s: Expected %s, got %s", test.version, test.expected, b)
+		}
+	}
+
+	// 1.4 codec and the old JSON() must agree
+	if b, _ := LookupCodec("1.4").EncodeCommand(cmd); string(b) != string(cmd.JSON()) {
+		t.Errorf("Expected %s, got %s", cmd.JSON(), b)
+	}
+}
+
+// Custom command that encodes itself.
+type rawCommand []byte
+
+func (c rawCommand) JSON() []byte { return c }
+
+func TestCodecCustomCommand(t *testing.T) {
+	for _, version := range Versions() {
+		if b, err := LookupCodec(version).EncodeCommand(rawCommand(`{"__class__":"Taunt"}`)); err != nil || string(b) != `{"__class__":"Taunt"}` {
+			t.Errorf("%s: Expected the command as it is, got %s (%v)", version, b, err)
+		}
+		if b, err := LookupCodec(version).EncodeCommand(rawCommand(nil)); err == nil {
+			t.Errorf("%s: Expected a command without JSON to be refused, got %q", version, b)
+		}
+	}
+}
+
+func TestCodecGameInfo(t *testing.T) {
+	for _, version := range Versions() {
+		gi, err := LookupCodec(version).DecodeGameInfo([]byte(json_gameinfo))
+		if err != nil {
+			t.Fatalf("%s: %s", version, err)
+		}
+		if len(gi.Team.Members) != 5 {
+			t.Errorf("%s: Expected 5 members, got %d", version, len(gi.Team.Members))
+		}
+	}
+	for _, version := range Versions() {
+		li, err := LookupCodec(version).DecodeLevelInfo([]byte(json_levelinfo))
+		if err != nil {
+			t.Fatalf("%s: %s", version, err)
+		}
+		if li.Width != 88 || len(li.TeamNames) != 2 {
+			t.Errorf("%s: Expected the LevelInfo of an 88 wide level with 2 teams, got %+v", version, li)
+		}
+		if _, err := LookupCodec(version).DecodeLevelInfo([]byte(`{"__class__": "LevelInfo"}`)); err == nil {
+			t.Errorf("%s: Expected an error for a missing LevelInfo", version)
+		}
+	}
+	if _, err := LookupCodec("1.4").DecodeGameInfo([]byte("{")); err == nil {
+		t.Errorf("Expected decode error")
+	}
+}
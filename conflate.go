@@ -0,0 +1,71 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+// NOTE: This file contains the "latest state" delivery mode for commanders slower than the tick rate.
+
+// Keep only the freshest GameInfo for the commander instead of queueing every tick behind it.
+// When a new <tick> arrives before the commander took the previous one, the previous one is dropped
+// and its combat events are merged into the new GameInfo, so none are lost.
+// Tick.Dropped and Client.Dropped() tell how many ticks were skipped.
+//
+// NOTE: The server is read at its own pace in this mode, a slow commander no longer holds up the socket.
+func Conflate() Option {
+	return func(o *options) {
+		o.conflate = true
+	}
+}
+
+// Number of ticks dropped in Conflate() mode during the whole session.
+func (cl *Client) Dropped() int {
+	return int(cl.dropped.Load())
+}
+
+// Adds ev to pending. In Conflate() mode a Tick replaces the undelivered Tick in pending, if there is one.
+// NOTE: Only dispatch() may call this.
+func (cl *Client) enqueue(pending []Event, ev Event) []Event {
+	tick, ok := ev.(*Tick)
+	if !ok || !cl.opts.conflate {
+		return append(pending, ev)
+	}
+	for i, old := range pending {
+		if old, ok := old.(*Tick); ok {
+			mergeCombatEvents(old.Game, tick.Game)
+			if cl.decoder != nil {
+				cl.decoder.release(old.Game)
+			}
+			tick.Dropped += old.Dropped + 1
+			cl.dropped.Add(1)
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	return append(pending, tick)
+}
+
+// Puts copies of the combat events of old that cur doesn't have in front of the events of cur,
+// so that old can be reused in ReuseGameInfo() mode.
+func mergeCombatEvents(old, cur *GameInfo) {
+	if old == nil || old.Match == nil || cur == nil || cur.Match == nil {
+		return
+	}
+	var merged []*CombatEvent
+	for _, ev := range old.Match.CombatEvents {
+		if !hasCombatEvent(cur.Match.CombatEvents, ev) {
+			ev := *ev
+			merged = append(merged, &ev)
+		}
+	}
+	cur.Match.CombatEvents = append(merged, cur.Match.CombatEvents...)
+}
+
+func hasCombatEvent(events []*CombatEvent, ev *CombatEvent) bool {
+	for _, v := range events {
+		if *v == *ev {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,15 @@
 package aisandbox
 
 // Each constructor starts with name and description.
-// All of them accept any amount of []float64 coordinates as the last parameter.
+// All of them accept any amount of Vec2 coordinates as the last parameter,
+// []float64{x, y} works as well.
 
 // NOTE: Defend is different from the other commands.
 // NewDefend accepts any amount of float64 slices that can have duration component as third value.
 // If duration is left out, it defaults to 0, which server translates to minimum allowed value.
 // 
 // Any mix of slices that have length two or three is allowed, slices of any other length are skipped.
+// Vec2 directions can be passed as they are, eg. NewDefend("bacon1", "Look north", aisandbox.V(0, -1)).
 //
 // tl;dr:
 // NewDefend("bacon1", "Is delicious", []float64{1.2, 2.3, 3.4}, []float64{7.2, -2.6})
@@ -35,19 +37,20 @@ func NewDefend(name, description string, directions ...[]float64) *Defend {
 }
 
 // If direction == nil, direction is ignored and bot will look forward while moving.
-func NewAttack(name, description string, direction []float64, target ...[]float64) *Attack {
+// Same goes for a direction that isn't [x, y].
+func NewAttack(name, description string, direction Vec2, target ...Vec2) *Attack {
 	command := &Attack{
 		Bot:         name,
 		Target:      target,
 		Description: description,
 	}
-	if direction != nil || len(direction) != 2 {
+	if len(direction) == 2 {
 		command.LookAt = direction
 	}
 	return command
 }
 
-func NewCharge(name, description string, target ...[]float64) *Charge {
+func NewCharge(name, description string, target ...Vec2) *Charge {
 	return &Charge{
 		Bot:         name,
 		Target:      target,
@@ -55,7 +58,7 @@ func NewCharge(name, description string, target ...[]float64) *Charge {
 	}
 }
 
-func NewMove(name, description string, target ...[]float64) *Move {
+func NewMove(name, description string, target ...Vec2) *Move {
 	return &Move{
 		Bot:         name,
 		Target:      target,
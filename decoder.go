@@ -0,0 +1,861 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// NOTE: This file contains the low-allocation <tick> decoder used by ReuseGameInfo().
+
+// Decodes every <tick> into recycled GameInfo structs instead of allocating new ones,
+// which cuts the garbage of a tick to a small fraction.
+//
+// The GameInfo of a Tick, and everything it points to, is valid until the commander receives
+// the next event. After that it's overwritten by a later tick, so copy whatever you want to keep:
+//
+//	last := *tick.Game.Team.Members["Blue0"] // copy of the BotInfo, but it still shares SeenBy etc.
+//
+// The GameInfo of GameStarted is never reused.
+// Only the GameInfo of 1.4 is decoded this way, sessions speaking 1.3 allocate every tick as usual.
+// NOTE: Not for Fanout, which hands the same GameInfo to several commanders at their own pace. NewFanout refuses it.
+func ReuseGameInfo() Option {
+	return func(o *options) {
+		o.reuse = true
+	}
+}
+
+// Implemented by the codecs whose GameInfo the gameDecoder understands, it decodes into json_GameInfo.
+type reusableCodec interface {
+	newGameDecoder() *gameDecoder
+}
+
+func (codec14) newGameDecoder() *gameDecoder {
+	return newGameDecoder()
+}
+
+// Parses GameInfo payloads into a json_GameInfo that is kept from one tick to the next,
+// and simplifies it into recycled GameInfo structs.
+// NOTE: decode is called by the reader only, release by dispatch() only.
+type gameDecoder struct {
+	raw    json_GameInfo
+	match  json_MatchInfo
+	teams  table[json_TeamInfo]
+	flags  table[json_FlagInfo]
+	bots   table[json_BotInfo]
+	scores map[string]float64
+	s      scanner
+
+	mu     sync.Mutex
+	free   []*GameInfo
+	arenas map[*GameInfo]*arena // every GameInfo the decoder has handed out
+}
+
+func newGameDecoder() *gameDecoder {
+	d := &gameDecoder{arenas: make(map[*GameInfo]*arena)}
+	d.raw.Value.Teams = make(map[string]*json_TeamInfo)
+	d.raw.Value.Flags = make(map[string]*json_FlagInfo)
+	d.raw.Value.Bots = make(map[string]*json_BotInfo)
+	d.scores = make(map[string]float64)
+	return d
+}
+
+// Same as codec14.DecodeGameInfo, but the GameInfo comes from the free list.
+// Payloads the scanner doesn't take are left to json.Unmarshal, so both the GameInfo and the errors
+// are the same as codec14 would give.
+func (d *gameDecoder) decode(b []byte) (*GameInfo, error) {
+	s := &d.s
+	*s = scanner{b: b, buf: s.buf}
+	d.parse(s)
+	raw := &d.raw
+	if s.err != nil {
+		raw = new(json_GameInfo)
+		if err := json.Unmarshal(b, raw); err != nil {
+			return nil, err
+		}
+	}
+	gi, a := d.get()
+	return gi, raw.simplifyInto(gi, a)
+}
+
+func (d *gameDecoder) get() (*GameInfo, *arena) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n := len(d.free); n > 0 {
+		gi := d.free[n-1]
+		d.free = d.free[:n-1]
+		return gi, d.arenas[gi]
+	}
+	gi, a := new(GameInfo), new(arena)
+	d.arenas[gi] = a
+	return gi, a
+}
+
+// Puts gi back to the free list. GameInfos the decoder didn't make are ignored.
+func (d *gameDecoder) release(gi *GameInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.arenas[gi]; ok {
+		d.free = append(d.free, gi)
+	}
+}
+
+// Fills d.raw like json.Unmarshal would.
+func (d *gameDecoder) parse(s *scanner) {
+	v := &d.raw.Value
+	team, enemy := v.Team, v.EnemyTeam
+	v.Team, v.EnemyTeam, v.Match = "", "", nil
+
+	if !s.null() {
+		s.value(gameKeys, func(key []byte) {
+			switch string(key) {
+			case "teams":
+				entries(s, &d.teams, func(t *json_TeamInfo) { d.team(s, t) })
+			case "team":
+				v.Team = text(s.str(), team)
+			case "enemyTeam":
+				v.EnemyTeam = text(s.str(), enemy)
+			case "flags":
+				entries(s, &d.flags, func(f *json_FlagInfo) { d.flag(s, f) })
+			case "bots":
+				entries(s, &d.bots, func(b *json_BotInfo) { d.bot(s, b) })
+			case "match":
+				v.Match = nil
+				if !s.null() {
+					d.matchInfo(s)
+					v.Match = &d.match
+				}
+			}
+		})
+	}
+	if s.peek() != 0 {
+		s.fail("unexpected data after GameInfo")
+	}
+	d.teams.sweep(v.Teams)
+	d.flags.sweep(v.Flags)
+	d.bots.sweep(v.Bots)
+}
+
+func (d *gameDecoder) team(s *scanner, t *json_TeamInfo) {
+	v := &t.Value
+	name, members := v.Name, v.Members
+	spawn, score, area := v.FlagSpawnLocation, v.FlagScoreLocation, v.BotSpawnArea
+	var zero json_TeamInfo
+	t.Value = zero.Value
+
+	s.value(teamKeys, func(key []byte) {
+		switch string(key) {
+		case "name":
+			v.Name = text(s.str(), name)
+		case "flag":
+			v.Flag = d.name(s.str())
+		case "members":
+			v.Members = d.names(s, members)
+		case "flagSpawnLocation":
+			v.FlagSpawnLocation = s.floats(spawn)
+		case "flagScoreLocation":
+			v.FlagScoreLocation = s.floats(score)
+		case "flagSpawnArea":
+			v.BotSpawnArea = s.matrix(area)
+		}
+	})
+}
+
+func (d *gameDecoder) flag(s *scanner, f *json_FlagInfo) {
+	v := &f.Value
+	name, position := v.Name, v.Position
+	var zero json_FlagInfo
+	f.Value = zero.Value
+
+	s.value(flagKeys, func(key []byte) {
+		switch string(key) {
+		case "name":
+			v.Name = text(s.str(), name)
+		case "team":
+			v.Team = d.name(s.str())
+		case "position":
+			v.Position = s.floats(position)
+		case "carrier":
+			if !s.null() {
+				v.Carrier = nstring(d.name(s.str()))
+			}
+		case "respawnTimer":
+			v.RespawnTimer = s.num()
+		}
+	})
+}
+
+func (d *gameDecoder) bot(s *scanner, b *json_BotInfo) {
+	v := &b.Value
+	name, position, facing := v.Name, v.Position, v.FacingDirection
+	visible, seenBy := v.VisibleEnemies, v.SeenBy
+	var zero json_BotInfo
+	b.Value = zero.Value
+
+	s.value(botKeys, func(key []byte) {
+		switch string(key) {
+		case "name":
+			v.Name = text(s.str(), name)
+		case "team":
+			v.Team = d.name(s.str())
+		case "position":
+			v.Position = s.floats(position)
+		case "facingDirection":
+			v.FacingDirection = s.floats(facing)
+		case "flag":
+			if !s.null() {
+				v.Flag = nstring(d.name(s.str()))
+			}
+		case "state":
+			v.State = BotState(int(s.nnum()))
+		case "health":
+			v.Health = nfloat64(s.nnum())
+		case "seenlast":
+			v.SeenLast = nfloat64(s.nnum())
+		case "visibleEnemies":
+			v.VisibleEnemies = d.names(s, visible)
+		case "seenBy":
+			v.SeenBy = d.names(s, seenBy)
+		}
+	})
+}
+
+func (d *gameDecoder) matchInfo(s *scanner) {
+	v := &d.match.Value
+	events, scores := v.CombatEvents, d.scores
+	var zero json_MatchInfo
+	d.match.Value = zero.Value
+	clear(scores)
+
+	s.value(matchKeys, func(key []byte) {
+		switch string(key) {
+		case "timeRemaining":
+			v.TimeRemaining = s.num()
+		case "timeToNextRespawn":
+			v.TimeToNextRespawn = s.num()
+		case "timePassed":
+			v.TimePassed = s.num()
+		case "combatEvents":
+			v.CombatEvents = d.combatEvents(s, events)
+		case "scores":
+			v.Scores = nil
+			if !s.null() {
+				s.object(func(key []byte) { scores[d.name(key)] = s.num() })
+				v.Scores = scores
+			}
+		}
+	})
+}
+
+// Reuses the events of dst, including the structs they point to.
+func (d *gameDecoder) combatEvents(s *scanner, dst []*json_MatchCombatEvent) []*json_MatchCombatEvent {
+	if s.null() {
+		return nil
+	}
+	events := dst[:0]
+	if events == nil {
+		events = []*json_MatchCombatEvent{}
+	}
+	s.array(func() {
+		var ev *json_MatchCombatEvent
+		if n := len(events); n < cap(events) {
+			ev = events[:n+1][n]
+		}
+		if s.null() {
+			events = append(events, nil)
+			return
+		}
+		if ev == nil {
+			ev = &json_MatchCombatEvent{Value: new(json_CombatEvent)}
+		}
+		value := ev.Value
+		if value == nil {
+			value = new(json_CombatEvent)
+		}
+		ev.Value = nil
+		s.fields(classKeys, func(key []byte) {
+			if string(key) == "__class__" {
+				s.class()
+				return
+			}
+			if s.null() {
+				return
+			}
+			*value = json_CombatEvent{}
+			ev.Value = value
+			s.fields(eventKeys, func(key []byte) {
+				switch string(key) {
+				case "type":
+					value.Type = EventType(int(s.nnum()))
+				case "instigator":
+					if !s.null() {
+						value.Instigator = nstring(d.name(s.str()))
+					}
+				case "subject":
+					value.Subject = d.name(s.str())
+				case "time":
+					value.Time = s.num()
+				}
+			})
+		})
+		events = append(events, ev)
+	})
+	return events
+}
+
+// Names of bots, flags and teams are taken from the tables, so they aren't allocated every tick.
+func (d *gameDecoder) name(b []byte) string {
+	if e := d.bots.entries[string(b)]; e != nil {
+		return e.key
+	}
+	if e := d.flags.entries[string(b)]; e != nil {
+		return e.key
+	}
+	if e := d.teams.entries[string(b)]; e != nil {
+		return e.key
+	}
+	return string(b)
+}
+
+func (d *gameDecoder) names(s *scanner, dst []string) []string {
+	if s.null() {
+		return nil
+	}
+	dst = dst[:0]
+	if dst == nil {
+		dst = []string{}
+	}
+	s.array(func() { dst = append(dst, d.name(s.str())) })
+	return dst
+}
+
+// Returns old if it's the same as b, to keep the string of the previous tick.
+func text(b []byte, old string) string {
+	if string(b) == old {
+		return old
+	}
+	return string(b)
+}
+
+// Entries of a JSON object that are kept from one tick to the next,
+// so that decoding the same keys again doesn't allocate.
+type table[T any] struct {
+	entries map[string]*entry[T]
+	gen     int
+}
+
+type entry[T any] struct {
+	key string
+	gen int // latest tick the entry was seen in
+	v   T
+}
+
+// Entry of key, marked as seen in the current tick.
+func (t *table[T]) get(key []byte) *entry[T] {
+	e := t.entries[string(key)]
+	if e == nil {
+		if t.entries == nil {
+			t.entries = make(map[string]*entry[T])
+		}
+		e = &entry[T]{key: string(key)}
+		t.entries[e.key] = e
+	}
+	e.gen = t.gen
+	return e
+}
+
+// Fills m with the entries seen in the current tick, forgets the rest and starts the next tick.
+func (t *table[T]) sweep(m map[string]*T) {
+	clear(m)
+	for key, e := range t.entries {
+		if e.gen != t.gen {
+			delete(t.entries, key)
+			continue
+		}
+		m[key] = &e.v
+	}
+	t.gen++
+}
+
+// Calls entry for every key of an object whose value isn't null, with the entry of the key in t.
+// A repeated key fails, json.Unmarshal would keep the last one even if it's null.
+func entries[T any](s *scanner, t *table[T], entry func(v *T)) {
+	if s.null() {
+		return
+	}
+	s.object(func(key []byte) {
+		if e := t.entries[string(key)]; e != nil && e.gen == t.gen {
+			s.fail("repeated key")
+			return
+		}
+		if !s.null() {
+			entry(&t.get(key).v)
+		}
+	})
+}
+
+// Keys of the structs in json.go, see (*scanner).fields.
+var (
+	classKeys = []string{"__class__", "__value__"}
+	gameKeys  = []string{"teams", "team", "enemyTeam", "flags", "bots", "match"}
+	teamKeys  = []string{"name", "flag", "members", "flagSpawnLocation", "flagScoreLocation", "flagSpawnArea"}
+	flagKeys  = []string{"name", "team", "position", "carrier", "respawnTimer"}
+	botKeys   = []string{"name", "team", "position", "facingDirection", "flag", "state", "health", "seenlast", "visibleEnemies", "seenBy"}
+	matchKeys = []string{"timeRemaining", "timeToNextRespawn", "combatEvents", "timePassed", "scores"}
+	eventKeys = []string{"type", "instigator", "subject", "time"}
+)
+
+// Same as encoding/json.
+const maxDepth = 10000
+
+// Just enough of a JSON parser for GameInfo, it reads b in place.
+// The first error stops parsing, the rest of the calls return zero values.
+// It only takes JSON that json.Unmarshal would decode the same way, anything else fails
+// and is left to json.Unmarshal by decode.
+type scanner struct {
+	b     []byte
+	i     int
+	err   error
+	buf   []byte // unescaped strings
+	depth int    // of skip
+}
+
+func (s *scanner) fail(what string) {
+	if s.err == nil {
+		s.err = fmt.Errorf("invalid GameInfo at offset %d: %s", s.i, what)
+	}
+	s.i = len(s.b)
+}
+
+// Skips whitespace and returns the next byte, 0 at the end.
+func (s *scanner) peek() byte {
+	for s.i < len(s.b) {
+		switch c := s.b[s.i]; c {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+func (s *scanner) consume(c byte) bool {
+	if s.peek() == c {
+		s.i++
+		return true
+	}
+	return false
+}
+
+func (s *scanner) literal(word string) bool {
+	if s.peek() == word[0] && len(s.b)-s.i >= len(word) && string(s.b[s.i:s.i+len(word)]) == word {
+		s.i += len(word)
+		return true
+	}
+	return false
+}
+
+// Consumes null if it's next.
+func (s *scanner) null() bool {
+	return s.literal("null")
+}
+
+// Calls field for every key of an object, field must consume the value.
+// The key is valid until the next string is read.
+func (s *scanner) object(field func(key []byte)) {
+	if !s.consume('{') {
+		s.fail("expected object")
+		return
+	}
+	if s.consume('}') {
+		return
+	}
+	for s.err == nil {
+		key := s.str()
+		if !s.consume(':') {
+			s.fail("expected ':'")
+			return
+		}
+		field(key)
+		if s.consume(',') {
+			continue
+		}
+		if !s.consume('}') {
+			s.fail("expected ',' or '}'")
+		}
+		return
+	}
+}
+
+// Calls elem for every element of an array, elem must consume the element.
+func (s *scanner) array(elem func()) {
+	if !s.consume('[') {
+		s.fail("expected array")
+		return
+	}
+	if s.consume(']') {
+		return
+	}
+	for s.err == nil {
+		elem()
+		if s.consume(',') {
+			continue
+		}
+		if !s.consume(']') {
+			s.fail("expected ',' or ']'")
+		}
+		return
+	}
+}
+
+// Calls field for the keys of an object that are in keys, the rest are skipped.
+// A repeated key or one that differs from keys only in case fails, json.Unmarshal would
+// decode both into the same field.
+func (s *scanner) fields(keys []string, field func(key []byte)) {
+	var seen uint64
+	s.object(func(key []byte) {
+		for i, k := range keys {
+			if string(key) != k {
+				continue
+			}
+			if seen&(1<<i) != 0 {
+				s.fail("repeated key")
+				return
+			}
+			seen |= 1 << i
+			field(key)
+			return
+		}
+		for _, k := range keys {
+			if bytes.EqualFold(key, []byte(k)) {
+				s.fail("key in different case")
+				return
+			}
+		}
+		s.skip()
+	})
+}
+
+// Calls field for the keys of __value__ in {"__class__": ..., "__value__": {...}}.
+func (s *scanner) value(keys []string, field func(key []byte)) {
+	s.fields(classKeys, func(key []byte) {
+		if string(key) == "__class__" {
+			s.class()
+			return
+		}
+		if !s.null() {
+			s.fields(keys, field)
+		}
+	})
+}
+
+// Consumes the value of __class__, a string or null.
+func (s *scanner) class() {
+	if !s.null() {
+		s.str()
+	}
+}
+
+// Returns the bytes of a string, valid until the next string is read.
+func (s *scanner) str() []byte {
+	if !s.consume('"') {
+		s.fail("expected string")
+		return nil
+	}
+	start := s.i
+	ascii := true
+	for ; s.i < len(s.b); s.i++ {
+		switch c := s.b[s.i]; {
+		case c == '"':
+			if !ascii && !utf8.Valid(s.b[start:s.i]) {
+				return s.escaped(start - 1)
+			}
+			s.i++
+			return s.b[start : s.i-1]
+		case c == '\\':
+			return s.escaped(start - 1)
+		case c < ' ':
+			s.fail("control character in string")
+			return nil
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	s.fail("unterminated string")
+	return nil
+}
+
+// Rare enough to leave to encoding/json, which also replaces invalid UTF-8.
+func (s *scanner) escaped(start int) []byte {
+	end := s.i
+	for ; end < len(s.b) && s.b[end] != '"'; end++ {
+		if s.b[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(s.b) {
+		s.fail("unterminated string")
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(s.b[start:end+1], &str); err != nil {
+		s.fail(err.Error())
+		return nil
+	}
+	s.i = end + 1
+	s.buf = append(s.buf[:0], str...)
+	return s.buf
+}
+
+// Reads a number as RFC 8259 has it, eg. no leading zeros or '+'.
+func (s *scanner) num() float64 {
+	s.peek()
+	start := s.i
+	s.next('-')
+	ok := s.next('0') || s.digits() > 0
+	if ok && s.next('.') {
+		ok = s.digits() > 0
+	}
+	if ok && (s.next('e') || s.next('E')) {
+		_ = s.next('+') || s.next('-')
+		ok = s.digits() > 0
+	}
+	if !ok {
+		s.fail("expected number")
+		return 0
+	}
+	f, err := strconv.ParseFloat(string(s.b[start:s.i]), 64)
+	if err != nil {
+		s.fail("number out of range")
+	}
+	return f
+}
+
+// Consumes c if it's the next byte, unlike consume whitespace isn't skipped.
+func (s *scanner) next(c byte) bool {
+	if s.i < len(s.b) && s.b[s.i] == c {
+		s.i++
+		return true
+	}
+	return false
+}
+
+// Consumes digits and returns how many there were.
+func (s *scanner) digits() int {
+	start := s.i
+	for s.i < len(s.b) && s.b[s.i] >= '0' && s.b[s.i] <= '9' {
+		s.i++
+	}
+	return s.i - start
+}
+
+// Number or null, null is 0.
+func (s *scanner) nnum() float64 {
+	if s.null() {
+		return 0
+	}
+	return s.num()
+}
+
+func (s *scanner) floats(dst []float64) []float64 {
+	if s.null() {
+		return nil
+	}
+	dst = dst[:0]
+	if dst == nil {
+		dst = []float64{}
+	}
+	s.array(func() { dst = append(dst, s.num()) })
+	return dst
+}
+
+// Reuses the rows of dst as well.
+func (s *scanner) matrix(dst [][]float64) [][]float64 {
+	if s.null() {
+		return nil
+	}
+	dst = dst[:0]
+	if dst == nil {
+		dst = [][]float64{}
+	}
+	s.array(func() {
+		var row []float64
+		if n := len(dst); n < cap(dst) {
+			row = dst[:n+1][n]
+		}
+		dst = append(dst, s.floats(row))
+	})
+	return dst
+}
+
+// Skips any value.
+func (s *scanner) skip() {
+	if s.depth++; s.depth > maxDepth {
+		s.fail("nested too deep")
+		return
+	}
+	switch s.peek() {
+	case '{':
+		s.object(func([]byte) { s.skip() })
+	case '[':
+		s.array(s.skip)
+	case '"':
+		s.str()
+	case 't':
+		if !s.literal("true") {
+			s.fail("invalid literal")
+		}
+	case 'f':
+		if !s.literal("false") {
+			s.fail("invalid literal")
+		}
+	case 'n':
+		if !s.null() {
+			s.fail("invalid literal")
+		}
+	default:
+		s.num()
+	}
+	s.depth--
+}
+
+// Storage of one recycled GameInfo. A nil arena allocates everything anew, see simplifyInto.
+type arena struct {
+	teams   [2]TeamInfo
+	flags   [2]FlagInfo
+	match   MatchInfo
+	members [2]map[string]*BotInfo
+
+	bots   []*BotInfo
+	events []*CombatEvent
+	used   int // of bots
+	fired  int // of events
+
+	floats []float64
+	vecs   []Vec2
+	links  []*BotInfo
+	combat []*CombatEvent
+}
+
+// Starts filling the arena from the beginning, the GameInfo using it must not be in use anymore.
+func (a *arena) reset() {
+	if a == nil {
+		return
+	}
+	a.used, a.fired = 0, 0
+	a.floats, a.vecs, a.links, a.combat = a.floats[:0], a.vecs[:0], a.links[:0], a.combat[:0]
+}
+
+// i is 0 for the own team, 1 for the enemy.
+func (a *arena) team(i int) *TeamInfo {
+	if a == nil {
+		return new(TeamInfo)
+	}
+	a.teams[i] = TeamInfo{}
+	return &a.teams[i]
+}
+
+func (a *arena) flag(i int) *FlagInfo {
+	if a == nil {
+		return new(FlagInfo)
+	}
+	a.flags[i] = FlagInfo{}
+	return &a.flags[i]
+}
+
+func (a *arena) matchInfo() *MatchInfo {
+	if a == nil {
+		return new(MatchInfo)
+	}
+	a.match = MatchInfo{}
+	return &a.match
+}
+
+func (a *arena) membersOf(i, n int) map[string]*BotInfo {
+	if a == nil {
+		return make(map[string]*BotInfo, n)
+	}
+	if a.members[i] == nil {
+		a.members[i] = make(map[string]*BotInfo, n)
+	}
+	clear(a.members[i])
+	return a.members[i]
+}
+
+func (a *arena) bot() *BotInfo {
+	if a == nil {
+		return new(BotInfo)
+	}
+	if a.used == len(a.bots) {
+		a.bots = append(a.bots, new(BotInfo))
+	}
+	bot := a.bots[a.used]
+	*bot = BotInfo{}
+	a.used++
+	return bot
+}
+
+func (a *arena) event() *CombatEvent {
+	if a == nil {
+		return new(CombatEvent)
+	}
+	if a.fired == len(a.events) {
+		a.events = append(a.events, new(CombatEvent))
+	}
+	ev := a.events[a.fired]
+	a.fired++
+	return ev
+}
+
+// Copy of v, or v itself without an arena.
+func (a *arena) vec(v []float64) Vec2 {
+	if a == nil || len(v) == 0 {
+		return v
+	}
+	n := len(a.floats)
+	a.floats = append(a.floats, v...)
+	return a.floats[n:len(a.floats):len(a.floats)]
+}
+
+func (a *arena) area(vs [][]float64) []Vec2 {
+	if a == nil || len(vs) == 0 {
+		return Vec2s(vs)
+	}
+	n := len(a.vecs)
+	for _, v := range vs {
+		a.vecs = append(a.vecs, a.vec(v))
+	}
+	return a.vecs[n:len(a.vecs):len(a.vecs)]
+}
+
+// Empty slice with room for n bots, nil without an arena.
+func (a *arena) linksFor(n int) []*BotInfo {
+	if a == nil || n == 0 {
+		return nil
+	}
+	if cap(a.links)-len(a.links) < n {
+		a.links = make([]*BotInfo, 0, 2*cap(a.links)+n)
+	}
+	l := len(a.links)
+	a.links = a.links[:l+n]
+	return a.links[l : l : l+n]
+}
+
+// Empty slice with room for n events, nil without an arena.
+func (a *arena) eventsFor(n int) []*CombatEvent {
+	if a == nil || n == 0 {
+		return nil
+	}
+	if cap(a.combat) < n {
+		a.combat = make([]*CombatEvent, 0, n)
+	}
+	return a.combat[:0:n]
+}
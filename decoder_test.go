@@ -0,0 +1,261 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// GameInfo payloads of the test frames, and some that json.Unmarshal takes but the server doesn't send.
+func gameInfoPayloads(t testing.TB) map[string]string {
+	payloads := map[string]string{
+		"gameinfo":   json_gameinfo,
+		"init":       strings.Split(json_init, "\n")[2],
+		"tick":       strings.Split(json_tick, "\n")[1],
+		"escaped":    strings.Replace(json_gameinfo, `"Blue0"`, `"Blue\u0030"`, -1),
+		"unknown":    strings.Replace(json_gameinfo, `"match":`, `"extra": [{"a": [true, false, null, -1.5e3, "]}"]}], "match":`, 1),
+		"null match": strings.Replace(json_gameinfo, `"match":`, `"match": null, "old":`, 1),
+		"empty":      `{"__class__": "GameInfo", "__value__": {}}`,
+		"null":       `null`,
+	}
+
+	breaks := map[string]func(v *json_GameInfo){
+		"missing bot":   func(v *json_GameInfo) { delete(v.Value.Bots, "Blue3") },
+		"missing team":  func(v *json_GameInfo) { delete(v.Value.Teams, "Red") },
+		"unknown enemy": func(v *json_GameInfo) { delete(v.Value.Bots, "Red2") },
+		"null event":    func(v *json_GameInfo) { v.Value.Match.Value.CombatEvents[1] = nil },
+		"empty event":   func(v *json_GameInfo) { v.Value.Match.Value.CombatEvents[2].Value = nil },
+		"no events":     func(v *json_GameInfo) { v.Value.Match.Value.CombatEvents = nil },
+	}
+	for name, break_ := range breaks {
+		s := new(json_GameInfo)
+		if err := json.Unmarshal([]byte(json_gameinfo), s); err != nil {
+			t.Fatalf(err.Error())
+		}
+		break_(s)
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		payloads[name] = string(b)
+	}
+	return payloads
+}
+
+func TestGameDecoder(t *testing.T) {
+	payloads := gameInfoPayloads(t)
+	order := []string{"gameinfo", "tick", "missing bot", "escaped", "init", "null event", "gameinfo", "empty",
+		"unknown enemy", "null match", "no events", "null", "missing team", "empty event", "unknown", "tick"}
+
+	d := newGameDecoder()
+	var kept *GameInfo
+	for i, name := range order {
+		expected, expectedErr := codec14{}.DecodeGameInfo([]byte(payloads[name]))
+		gi, err := d.decode([]byte(payloads[name]))
+		if gi == nil {
+			t.Fatalf("%s: Expected GameInfo, got error %v", name, err)
+		}
+		if !reflect.DeepEqual(gi, expected) {
+			t.Errorf("%s: Reused GameInfo differs from a new one", name)
+		}
+		if (err == nil) != (expectedErr == nil) || (err != nil && err.Error() != expectedErr.Error()) {
+			t.Errorf("%s: Expected error %v, got %v", name, expectedErr, err)
+		}
+
+		// The first one isn't released, later ticks must not touch it.
+		if i == 0 {
+			kept = gi
+			continue
+		}
+		d.release(gi)
+	}
+	expected, _ := codec14{}.DecodeGameInfo([]byte(json_gameinfo))
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Unreleased GameInfo was modified by later ticks")
+	}
+	d.release(new(GameInfo))
+	if len(d.free) != 1 {
+		t.Errorf("Expected 1 GameInfo in the free list, got %d", len(d.free))
+	}
+}
+
+func TestGameDecoderInvalid(t *testing.T) {
+	invalid := []string{
+		``,
+		`{`,
+		`{"__value__": {"bots": [}}`,
+		`{"__value__": {"team": 5}}`,
+		`{"__value__": {"match": {"__value__": {"timePassed": "1"}}}}`,
+		`{"__value__": {"teams": {"Blue": {"__value__": {"name": "Blue}}}}}`,
+		`{"__value__": {}} {}`,
+		`{"__value__": {"x": nul}}`,
+		`{"__value__": {"x": 01}}`,
+		`{"__value__": {"x": +1}}`,
+		`{"__value__": {"x": 1.}}`,
+		`{"__value__": {"x": -}}`,
+		"{\"__value__\": {\"team\": \"Bl\tue\"}}",
+		`{"__value__": {"x": ` + strings.Repeat("[", 20000) + strings.Repeat("]", 20000) + `}}`,
+	}
+	d := newGameDecoder()
+	for _, payload := range invalid {
+		if gi, err := d.decode([]byte(payload)); err == nil || gi != nil {
+			t.Errorf("%q: Expected error, got %v", payload, err)
+		}
+		if _, err := (codec14{}).DecodeGameInfo([]byte(payload)); err == nil {
+			t.Errorf("%q: Expected json.Unmarshal to fail as well", payload)
+		}
+	}
+	// Still works after errors.
+	if gi, err := d.decode([]byte(json_gameinfo)); err != nil || len(gi.Team.Members) != 5 {
+		t.Errorf("Expected 5 members, got %v", err)
+	}
+}
+
+// Compares the decoder to json.Unmarshal, go test -fuzz FuzzGameDecoder looks for more differences.
+func FuzzGameDecoder(f *testing.F) {
+	for _, payload := range gameInfoPayloads(f) {
+		f.Add(payload)
+	}
+	f.Add(strings.Replace(json_gameinfo, `"match":`, `"MATCH":`, 1))
+	f.Add(strings.Replace(json_gameinfo, `"team": "Blue"`, `"team": "Red", "team": "Blue"`, 1))
+	f.Add(strings.Replace(json_gameinfo, `"Blue0"`, "\"Blue\xff\"", -1))
+	f.Add(`{"__class__": 5, "__value__": {}}`)
+	f.Add(`{"__value__": {"bots": {"Blue0": {}, "Blue0": null}}}`)
+	f.Add(`{"__value__": {"match": {"__value__": {"timePassed": 1e400}}}}`)
+
+	d := newGameDecoder()
+	f.Fuzz(func(t *testing.T, payload string) {
+		expected, expectedErr := codec14{}.DecodeGameInfo([]byte(payload))
+		gi, err := d.decode([]byte(payload))
+		if (err == nil) != (expectedErr == nil) || (err != nil && err.Error() != expectedErr.Error()) {
+			t.Fatalf("Expected error %v, got %v", expectedErr, err)
+		}
+		if !reflect.DeepEqual(gi, expected) {
+			t.Fatalf("GameInfo differs from json.Unmarshal")
+		}
+		if gi != nil {
+			d.release(gi)
+		}
+	})
+}
+
+func TestGameDecoderAllocs(t *testing.T) {
+	b := []byte(json_gameinfo)
+	d := newGameDecoder()
+	reused := testing.AllocsPerRun(100, func() {
+		gi, _ := d.decode(b)
+		d.release(gi)
+	})
+	allocated := testing.AllocsPerRun(100, func() {
+		codec14{}.DecodeGameInfo(b)
+	})
+	if reused > allocated/20 {
+		t.Errorf("Expected at most 5%% of %.0f allocations when reusing, got %.0f", allocated, reused)
+	}
+}
+
+func TestReuseGameInfo(t *testing.T) {
+	tick := func(i int) string {
+		return strings.Replace(json_tick, `"timePassed": 31.5719051361084`, fmt.Sprintf(`"timePassed": %d`, i), 1)
+	}
+	for _, conflate := range []bool{false, true} {
+		server, client := net.Pipe()
+		go func() {
+			defer server.Close()
+			go io.Copy(io.Discard, serveInit(server))
+			for i := 1; i <= 20; i++ {
+				server.Write([]byte(tick(i)))
+			}
+			server.Write([]byte(json_shutdown))
+		}()
+
+		opts := []Option{ReuseGameInfo()}
+		if conflate {
+			opts = append(opts, Conflate())
+		}
+		cl := Open(context.Background(), client, "Frugal", opts...)
+		var (
+			ticks int
+			games = make(map[*GameInfo]bool)
+			last  float64
+		)
+		for ev := range cl.Events() {
+			tick, ok := ev.(*Tick)
+			if !ok {
+				continue
+			}
+			ticks += tick.Dropped + 1
+			games[tick.Game] = true
+			if passed := tick.Game.Match.TimePassed; passed <= last || len(tick.Game.Team.Members) != 5 {
+				t.Errorf("Expected tick after %.0f with 5 members, got %.0f with %d", last, passed, len(tick.Game.Team.Members))
+			} else {
+				last = passed
+			}
+		}
+		cl.Close()
+
+		if ticks != 20 || last != 20 {
+			t.Errorf("conflate %v: Expected 20 ticks, got %d ending at %.0f", conflate, ticks, last)
+		}
+		if len(games) >= 20 {
+			t.Errorf("conflate %v: Expected GameInfos to be reused, got %d different ones", conflate, len(games))
+		}
+	}
+}
+
+func TestReuseGameInfo13(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		server.Write([]byte(strings.Replace(json_connect, "1.4", "1.3", 1)))
+		ReadFrame(r)
+		server.Write([]byte(json_init))
+		go io.Copy(io.Discard, r)
+		for i := 0; i < 20; i++ {
+			server.Write([]byte(json_tick))
+		}
+		server.Write([]byte(json_shutdown))
+	}()
+
+	// 1.3 is decoded through its own structs, the option is ignored.
+	cl := Open(context.Background(), client, "Frugal", ReuseGameInfo())
+	games := make(map[*GameInfo]bool)
+	for ev := range cl.Events() {
+		if tick, ok := ev.(*Tick); ok {
+			games[tick.Game] = true
+		}
+	}
+	cl.Close()
+	if len(games) != 20 {
+		t.Errorf("Expected 20 different GameInfos under 1.3, got %d", len(games))
+	}
+}
+
+func BenchmarkDecodeGameInfo(b *testing.B) {
+	payload := []byte(json_gameinfo)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		codec14{}.DecodeGameInfo(payload)
+	}
+}
+
+func BenchmarkDecodeGameInfoReuse(b *testing.B) {
+	payload := []byte(json_gameinfo)
+	d := newGameDecoder()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		gi, _ := d.decode(payload)
+		d.release(gi)
+	}
+}
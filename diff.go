@@ -0,0 +1,188 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"sort"
+)
+
+// NOTE: This file contains the tick-to-tick diff of GameInfo.
+
+// Adds Tick.Changes, the changes since the GameInfo the commander received before the tick.
+// With Conflate() that includes the changes of the dropped ticks.
+func Diffs() Option {
+	return func(o *options) {
+		o.diffs = true
+	}
+}
+
+// What changed between two GameInfos, see Diff.
+// Bots and flags point to the newer GameInfo, the old values are copies.
+// Every list is sorted by bot name, own team first.
+type Changes struct {
+	Died      []*BotInfo // own and enemy bots
+	Respawned []*BotInfo
+	States    []StateChange
+	Moved     []BotMove // only bots with known positions in both, ie. visible enemies
+	Health    []HealthChange
+	Spotted   []*BotInfo // enemies that became visible
+	Lost      []*BotInfo // enemies that aren't visible anymore
+	Flags     []FlagChange
+	Scores    []ScoreChange
+}
+
+type StateChange struct {
+	Bot  *BotInfo
+	From BotState
+}
+
+type BotMove struct {
+	Bot  *BotInfo
+	From Vec2
+}
+
+type HealthChange struct {
+	Bot  *BotInfo
+	From float64
+}
+
+// Flag that moved or changed carrier.
+type FlagChange struct {
+	Team    string // owner of the flag
+	Flag    *FlagInfo
+	From    Vec2   // previous position, nil if unknown
+	Carrier string // previous carrier, "" if nobody carried it
+}
+
+// True if the flag was picked up, dropped or passed to another bot.
+func (c FlagChange) CarrierChanged() bool {
+	var carrier string
+	if c.Flag.Carrier != nil {
+		carrier = c.Flag.Carrier.Name
+	}
+	return carrier != c.Carrier
+}
+
+type ScoreChange struct {
+	Team string
+	From float64
+	To   float64
+}
+
+// True if nothing changed.
+func (c *Changes) Empty() bool {
+	return len(c.Died) == 0 && len(c.Respawned) == 0 && len(c.States) == 0 && len(c.Moved) == 0 && len(c.Health) == 0 &&
+		len(c.Spotted) == 0 && len(c.Lost) == 0 && len(c.Flags) == 0 && len(c.Scores) == 0
+}
+
+// Compares cur to prev, eg. the previous and the current tick. Bots are matched by name,
+// bots that are only in one of them are left out. If prev is nil nothing has changed.
+//
+// A bot died if its state became STATE_DEAD, or its health dropped to 0 while its state is known;
+// enemies that go out of sight aren't counted as dead.
+// NOTE: Nothing of prev is kept, so it can be a GameInfo that is about to be reused (see ReuseGameInfo()).
+func Diff(prev, cur *GameInfo) *Changes {
+	c := new(Changes)
+	if prev == nil || cur == nil {
+		return c
+	}
+	teams := [][2]*TeamInfo{{prev.Team, cur.Team}, {prev.EnemyTeam, cur.EnemyTeam}}
+	for i, team := range teams {
+		old, now := team[0], team[1]
+		if old == nil || now == nil {
+			continue
+		}
+		c.bots(old.Members, now.Members, i == 1)
+		c.flag(old, now)
+		if old.Score != now.Score {
+			c.Scores = append(c.Scores, ScoreChange{Team: now.Name, From: old.Score, To: now.Score})
+		}
+	}
+	return c
+}
+
+func (c *Changes) bots(old, now map[string]*BotInfo, enemy bool) {
+	names := make([]string, 0, len(now))
+	for name := range now {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		o, n := old[name], now[name]
+		if o == nil || n == nil {
+			continue
+		}
+		switch {
+		case !isDead(o) && isDead(n):
+			c.Died = append(c.Died, n)
+		case isDead(o) && !isDead(n) && n.State != STATE_UNKNOWN:
+			c.Respawned = append(c.Respawned, n)
+		}
+		if o.State != n.State {
+			c.States = append(c.States, StateChange{Bot: n, From: o.State})
+		}
+		if o.Position != nil && n.Position != nil && !equalVec(o.Position, n.Position) {
+			c.Moved = append(c.Moved, BotMove{Bot: n, From: copyVec(o.Position)})
+		}
+		if o.State != STATE_UNKNOWN && n.State != STATE_UNKNOWN && o.Health != n.Health {
+			c.Health = append(c.Health, HealthChange{Bot: n, From: o.Health})
+		}
+		if enemy {
+			switch {
+			case o.Position == nil && n.Position != nil:
+				c.Spotted = append(c.Spotted, n)
+			case o.Position != nil && n.Position == nil:
+				c.Lost = append(c.Lost, n)
+			}
+		}
+	}
+}
+
+func (c *Changes) flag(old, now *TeamInfo) {
+	if old.Flag == nil || now.Flag == nil {
+		return
+	}
+	var carrier string
+	if old.Flag.Carrier != nil {
+		carrier = old.Flag.Carrier.Name
+	}
+	change := FlagChange{Team: now.Name, Flag: now.Flag, From: copyVec(old.Flag.Position), Carrier: carrier}
+	if !equalVec(old.Flag.Position, now.Flag.Position) || change.CarrierChanged() {
+		c.Flags = append(c.Flags, change)
+	}
+}
+
+// Dead by state, or by health if the state is known.
+func isDead(b *BotInfo) bool {
+	return b.State == STATE_DEAD || (b.State != STATE_UNKNOWN && b.Health <= 0)
+}
+
+func equalVec(v, o Vec2) bool {
+	if len(v) != len(o) || (v == nil) != (o == nil) {
+		return false
+	}
+	for i := range v {
+		if v[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyVec(v Vec2) Vec2 {
+	if v == nil {
+		return nil
+	}
+	return append(Vec2{}, v...)
+}
+
+// Fills in the Changes of ev if it's the next Tick the commander receives.
+// NOTE: Only dispatch() may call this.
+func (cl *Client) diff(ev Event) {
+	if tick, ok := ev.(*Tick); ok && cl.opts.diffs && tick.Changes == nil {
+		tick.Changes = Diff(cl.prevGame, tick.Game)
+	}
+}
@@ -0,0 +1,101 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	prev, _ := codec14{}.DecodeGameInfo([]byte(json_gameinfo))
+	cur, _ := codec14{}.DecodeGameInfo([]byte(json_gameinfo))
+	if c := Diff(prev, cur); !c.Empty() {
+		t.Fatalf("Expected no changes between equal GameInfos, got %+v", c)
+	}
+	if c := Diff(nil, cur); c == nil || !c.Empty() {
+		t.Errorf("Expected no changes without prev, got %+v", c)
+	}
+
+	blue, red := cur.Team.Members, cur.EnemyTeam.Members
+	blue["Blue0"].State, blue["Blue0"].Health = STATE_DEAD, 0
+	blue["Blue2"].State, blue["Blue2"].Health = STATE_IDLE, 100
+	blue["Blue1"].Position = V(10, 29)
+	red["Red3"].Position = nil
+	cur.EnemyTeam.Flag.Carrier = nil
+	cur.Team.Score = 1
+
+	c := Diff(prev, cur)
+	// Nothing of prev is kept.
+	prev.Team.Members["Blue1"].Position[0] = -1
+
+	names := func(bots []*BotInfo) string {
+		var s []string
+		for _, bot := range bots {
+			s = append(s, bot.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	if names(c.Died) != "Blue0" || names(c.Respawned) != "Blue2" || names(c.Lost) != "Red3" || len(c.Spotted) != 0 {
+		t.Errorf("Expected Blue0 died, Blue2 respawned and Red3 lost, got %s, %s and %s", names(c.Died), names(c.Respawned), names(c.Lost))
+	}
+	if len(c.States) != 2 || c.States[0].Bot.Name != "Blue0" || c.States[0].From != STATE_IDLE || c.States[1].From != STATE_SHOOTING {
+		t.Errorf("Expected state changes of Blue0 and Blue2, got %+v", c.States)
+	}
+	if len(c.Health) != 2 || c.Health[0].From != 100 || c.Health[1].Bot.Health != 100 {
+		t.Errorf("Expected health changes of Blue0 and Blue2, got %+v", c.Health)
+	}
+	if len(c.Moved) != 1 || c.Moved[0].Bot.Name != "Blue1" || c.Moved[0].From.X() < 9 {
+		t.Errorf("Expected Blue1 moved from its old position, got %+v", c.Moved)
+	}
+	if len(c.Flags) != 1 || c.Flags[0].Team != "Red" || c.Flags[0].Carrier != "Blue1" || !c.Flags[0].CarrierChanged() {
+		t.Errorf("Expected the red flag dropped by Blue1, got %+v", c.Flags)
+	}
+	if len(c.Scores) != 1 || c.Scores[0] != (ScoreChange{Team: "Blue", From: 0, To: 1}) {
+		t.Errorf("Expected Blue scored, got %+v", c.Scores)
+	}
+
+	// And back.
+	if c := Diff(cur, prev); names(c.Spotted) != "Red3" || names(c.Respawned) != "Blue0" || names(c.Died) != "Blue2" {
+		t.Errorf("Expected Red3 spotted, Blue0 respawned and Blue2 died, got %+v", c)
+	}
+}
+
+func TestDiffs(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		go io.Copy(io.Discard, serveInit(server))
+		for i := 0; i < 5; i++ {
+			server.Write([]byte(json_tick))
+		}
+		server.Write([]byte(json_shutdown))
+	}()
+
+	cl := Open(context.Background(), client, "Observant", Diffs(), ReuseGameInfo())
+	var changes []*Changes
+	for ev := range cl.Events() {
+		if tick, ok := ev.(*Tick); ok {
+			changes = append(changes, tick.Changes)
+		}
+	}
+	cl.Close()
+
+	if len(changes) != 5 {
+		t.Fatalf("Expected 5 ticks, got %d", len(changes))
+	}
+	// The game has started in between <initialize> and the first tick.
+	if changes[0] == nil || changes[0].Empty() {
+		t.Errorf("Expected changes since GameStarted, got %+v", changes[0])
+	}
+	for i, c := range changes[1:] {
+		if c == nil || !c.Empty() {
+			t.Errorf("Expected no changes between equal ticks, got %+v at %d", c, i+1)
+		}
+	}
+}
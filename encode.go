@@ -0,0 +1,122 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// NOTE: This file contains the encoding of LevelInfo and GameInfo back into the wire format,
+// for servers and simulators written in Go, see the server package.
+
+// Encodes li into the LevelInfo payload of <initialize>.
+func EncodeLevelInfo(li *LevelInfo) ([]byte, error) {
+	if li == nil {
+		return nil, errors.New("LevelInfo missing")
+	}
+	return json.Marshal(json_LevelInfo{Class: "LevelInfo", Value: li})
+}
+
+// Encodes gi into the GameInfo payload of <initialize> and <tick>, in the format of 1.4 and 1.3.
+// The simplified structs have lost the flag names, so the flag of a team is called
+// team name + "Flag" like the server does. BotInfo.Flag is expected to follow the same naming.
+// Returns an error if gi is missing either team.
+func EncodeGameInfo(gi *GameInfo) ([]byte, error) {
+	if gi == nil || gi.Team == nil || gi.EnemyTeam == nil {
+		return nil, errors.New("GameInfo without Team and EnemyTeam can't be encoded")
+	}
+
+	data := &json_GameInfo{Class: "GameInfo"}
+	v := &data.Value
+	v.Team, v.EnemyTeam = gi.Team.Name, gi.EnemyTeam.Name
+	v.Teams = make(map[string]*json_TeamInfo, 2)
+	v.Flags = make(map[string]*json_FlagInfo, 2)
+	v.Bots = make(map[string]*json_BotInfo)
+	match := &json_MatchInfo{Class: "MatchInfo"}
+	match.Value.Scores = make(map[string]float64, 2)
+	match.Value.CombatEvents = []*json_MatchCombatEvent{}
+
+	for _, team := range []*TeamInfo{gi.Team, gi.EnemyTeam} {
+		t := &json_TeamInfo{Class: "TeamInfo"}
+		t.Value.Name = team.Name
+		t.Value.Flag = team.Name + "Flag"
+		t.Value.FlagSpawnLocation = team.FlagSpawnLocation
+		t.Value.FlagScoreLocation = team.FlagScoreLocation
+		for _, corner := range team.BotSpawnArea {
+			t.Value.BotSpawnArea = append(t.Value.BotSpawnArea, corner)
+		}
+		t.Value.Members = []string{}
+		for name, bot := range team.Members {
+			if bot == nil {
+				continue
+			}
+			t.Value.Members = append(t.Value.Members, name)
+			v.Bots[name] = wireBot(bot)
+		}
+		sort.Strings(t.Value.Members)
+		v.Teams[team.Name] = t
+
+		if team.Flag != nil {
+			f := &json_FlagInfo{Class: "FlagInfo"}
+			f.Value.Name = t.Value.Flag
+			f.Value.Team = team.Name
+			f.Value.Position = team.Flag.Position
+			f.Value.RespawnTimer = team.Flag.RespawnTimer
+			if team.Flag.Carrier != nil {
+				f.Value.Carrier = nstring(team.Flag.Carrier.Name)
+			}
+			v.Flags[t.Value.Flag] = f
+		}
+		match.Value.Scores[team.Name] = team.Score
+	}
+
+	if m := gi.Match; m != nil {
+		match.Value.TimeRemaining = m.TimeRemaining
+		match.Value.TimeToNextRespawn = m.TimeToNextRespawn
+		match.Value.TimePassed = m.TimePassed
+		for _, event := range m.CombatEvents {
+			if event == nil {
+				continue
+			}
+			match.Value.CombatEvents = append(match.Value.CombatEvents, &json_MatchCombatEvent{
+				Class: "MatchCombatEvent",
+				Value: &json_CombatEvent{
+					Type:       event.Type,
+					Instigator: nstring(event.Instigator),
+					Subject:    event.Subject,
+					Time:       event.Time,
+				},
+			})
+		}
+	}
+	v.Match = match
+
+	return json.Marshal(data)
+}
+
+func wireBot(bot *BotInfo) *json_BotInfo {
+	b := &json_BotInfo{Class: "BotInfo"}
+	b.Value.Name = bot.Name
+	b.Value.Team = bot.Team
+	b.Value.Position = bot.Position
+	b.Value.FacingDirection = bot.FacingDirection
+	b.Value.Flag = nstring(bot.Flag)
+	b.Value.State = bot.State
+	b.Value.Health = nfloat64(bot.Health)
+	b.Value.SeenLast = nfloat64(bot.SeenLast)
+	b.Value.VisibleEnemies = botNames(bot.VisibleEnemies)
+	b.Value.SeenBy = botNames(bot.SeenBy)
+	return b
+}
+
+func botNames(bots []*BotInfo) []string {
+	names := make([]string, 0, len(bots))
+	for _, bot := range bots {
+		names = append(names, bot.Name)
+	}
+	return names
+}
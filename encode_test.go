@@ -0,0 +1,53 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeGameInfo(t *testing.T) {
+	codec := LookupCodec("1.4")
+	gi, err := codec.DecodeGameInfo([]byte(json_gameinfo))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b, err := EncodeGameInfo(gi)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	decoded, err := codec.DecodeGameInfo(b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(decoded, gi) {
+		t.Errorf("Expected the encoded GameInfo to decode back to itself, got %s", b)
+	}
+	if reused, err := newGameDecoder().decode(b); err != nil || !reflect.DeepEqual(reused, gi) {
+		t.Errorf("Expected the reusing decoder to agree, got %v", err)
+	}
+	if carrier := decoded.EnemyTeam.Flag.Carrier; carrier == nil || carrier.Name != "Blue1" {
+		t.Errorf("Expected RedFlag to be carried by Blue1, got %v", carrier)
+	}
+
+	if _, err := EncodeGameInfo(&GameInfo{Team: gi.Team}); err == nil {
+		t.Errorf("Expected a GameInfo without EnemyTeam to be refused")
+	}
+}
+
+func TestEncodeLevelInfo(t *testing.T) {
+	li, err := LookupCodec("1.4").DecodeLevelInfo([]byte(json_levelinfo))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b, err := EncodeLevelInfo(li)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decoded, err := LookupCodec("1.4").DecodeLevelInfo(b); err != nil || !reflect.DeepEqual(decoded, li) {
+		t.Errorf("Expected the encoded LevelInfo to decode back to itself, got %s (%v)", b, err)
+	}
+}
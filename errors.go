@@ -0,0 +1,64 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NOTE: This file contains the errors that are reported to the commander.
+
+// Error categories. Use errors.Is(err, aisandbox.ErrDecode) etc. to tell them apart.
+var (
+	ErrVersionMismatch = errors.New("protocol version mismatch") // server speaks a different protocol version, session ends
+	ErrDecode          = errors.New("frame decode failed")       // JSON payload of a frame couldn't be parsed, frame is skipped
+	ErrUnexpected      = errors.New("unexpected message")        // message arrived in the wrong order, eg. <tick> before <initialize>
+	ErrUnknownMessage  = errors.New("unknown message")           // server sent a message these bindings don't know about
+	ErrEOF             = errors.New("connection lost")           // reading from the server failed, session ends
+	ErrWrite           = errors.New("write failed")              // sending to the server failed, session ends
+	ErrEncode          = errors.New("command encode failed")     // command couldn't be encoded, eg. a Vec2 with three values, it's dropped
+	ErrStalled         = errors.New("server stalled")            // no frames within StallTimeout(), session ends with AbortOnStall()
+	ErrConflict        = errors.New("conflicting order")         // Fanout dropped an order for a bot of another sub-commander
+	ErrInconsistent    = errors.New("inconsistent game state")   // GameInfo refers to things it doesn't contain, it's delivered without them
+)
+
+// Underlying error when a Client that never connected (or failed to) is used.
+var errNotConnected = errors.New("not connected")
+
+// Error is the type of every error the session reports.
+type Error struct {
+	Kind  error  // One of the Err* categories
+	Frame string // Message that was being processed, eg. "<tick>"
+	Err   error  // Underlying error, can be nil
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Frame != "" {
+		msg = fmt.Sprintf("%s: %s", e.Frame, msg)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// Allows errors.Is to match both the category and the underlying error (eg. io.EOF).
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Inconsistencies found in a GameInfo, eg. a team member missing from bots or a null match.
+// Reported as the Err of an ErrInconsistent error.
+type Inconsistencies []string
+
+func (i Inconsistencies) Error() string {
+	return strings.Join(i, ", ")
+}
@@ -0,0 +1,278 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"time"
+)
+
+// NOTE: This file contains the events sent to the commander through Client.Events()
+
+// Event is anything the session sends to the commander.
+// Switch on the concrete type:
+//
+//	for ev := range client.Events() {
+//		switch e := ev.(type) {
+//		case *aisandbox.LevelLoaded:
+//		case *aisandbox.GameStarted:
+//		case *aisandbox.Tick:
+//		case *aisandbox.Shutdown:
+//		case *aisandbox.Disconnected:
+//		}
+//	}
+type Event interface {
+	isEvent()
+}
+
+// Sent once when the server sends <initialize>, before GameStarted.
+type LevelLoaded struct {
+	Level *LevelInfo
+}
+
+// The first GameInfo, sent right after LevelLoaded. Call Ready() after processing it.
+type GameStarted struct {
+	Game *GameInfo
+}
+
+// Sent for every <tick> from the server.
+type Tick struct {
+	Game    *GameInfo
+	Dropped int      // ticks skipped right before this one in Conflate() mode, their combat events are included in Game
+	Changes *Changes // since the previous GameInfo the commander received, only with the Diffs() option
+}
+
+// Last event of a session that ended normally, eg. server sent <shutdown>.
+type Shutdown struct {
+	Reason string
+}
+
+// Last event of a session that ended because of an error, eg. lost connection or version mismatch.
+// Err is of type *Error.
+type Disconnected struct {
+	Err error
+}
+
+func (*LevelLoaded) isEvent()  {}
+func (*GameStarted) isEvent()  {}
+func (*Tick) isEvent()         {}
+func (*Shutdown) isEvent()     {}
+func (*Disconnected) isEvent() {}
+
+// Shutdown reasons
+const (
+	REASON_SERVER = "server sent <shutdown>"
+)
+
+// A command from the commander, stamped with the GameInfo it had received when sending it.
+// Ready() requests travel the same way to keep them in order with the commands, those have ready set.
+type stampedCommand struct {
+	cmd   Command
+	tick  int
+	ready chan error // answered by the writer once <ready> is written
+}
+
+// Runs in the background and hands events from the reader to the commander, converted for In()
+// when that's used, and passes the commands from Out() on to the writer.
+// Both directions are handled in one goroutine so that a command can't be stamped with a GameInfo
+// that the commander hasn't received yet.
+// Closes Events() and In() at the end of the session, but keeps taking commands until Out() is closed.
+// If the session is cancelled or closed first, the rest of the commands are dropped, see dropCommands.
+func (cl *Client) dispatch() {
+	var (
+		pending  []Event
+		incoming = cl.incoming
+		out      = cl.out
+		mode     = cl.mode // nil once the commander picked Events() or In()
+		closing  = cl.closing
+		closed   bool // Events() and In() are closed
+	)
+	if interval := cl.watchdogInterval(); interval > 0 {
+		cl.watchdog = time.NewTimer(interval)
+		defer cl.watchdog.Stop()
+	}
+	defer cl.wg.Done()
+	defer close(cl.dispatched)
+	defer cl.commandsEnded()
+	defer func() {
+		if !closed {
+			close(cl.events)
+			close(cl.in)
+		}
+	}()
+	defer func() {
+		if out != nil {
+			go dropCommands(out)
+		}
+	}()
+
+	for !closed || out != nil {
+		// Found while passing commands on, see pass.
+		pending = append(pending, cl.stalls...)
+		cl.stalls = nil
+		cl.metrics.backlog.Store(int64(len(pending)))
+		if incoming == nil && len(pending) == 0 && !closed {
+			close(cl.events)
+			close(cl.in)
+			closed = true
+			continue
+		}
+
+		// Either wait for the commander to take the next event, or read a new one.
+		// In Conflate() mode new events are read while waiting, see enqueue().
+		var (
+			recv   <-chan Event
+			events chan<- Event
+			in     chan<- interface{}
+			next   Event
+			msg    interface{}
+			stall  <-chan time.Time
+		)
+		if incoming != nil {
+			stall = cl.watchdogC()
+		}
+		if len(pending) == 0 || cl.opts.conflate {
+			recv = incoming
+		}
+		if len(pending) > 0 && mode == nil {
+			next = pending[0]
+			cl.diff(next)
+			if !cl.legacy {
+				events = cl.events
+			} else if msg = legacyMessage(next); msg != nil {
+				in = cl.in
+			} else {
+				pending = pending[1:]
+				continue
+			}
+		}
+
+		select {
+		case <-mode:
+			mode = nil
+		case now := <-stall:
+			cl.watch(now)
+		case ev, ok := <-recv:
+			if !ok {
+				incoming = nil
+				continue
+			}
+			pending = append(pending, cl.takeQueued()...)
+			pending = cl.enqueue(pending, ev)
+		case events <- next:
+			pending = pending[1:]
+			cl.delivered(next)
+		case in <- msg:
+			pending = pending[1:]
+			cl.delivered(next)
+		case cmd, ok := <-out:
+			if !ok {
+				cl.endCommands()
+				out, closing = nil, nil
+				continue
+			}
+			if !cl.pass(cmd) {
+				return
+			}
+		case reply := <-cl.readyReq:
+			if out == nil {
+				// The writer is done already.
+				reply <- cl.ready(nil)
+			} else if !cl.passReady(reply) {
+				return
+			}
+		case <-closing:
+			// Pass on the commands that are already waiting and let the writer finish.
+			for drained := false; !drained && out != nil; {
+				select {
+				case cmd, ok := <-out:
+					if !ok {
+						out = nil
+					} else if !cl.pass(cmd) {
+						return
+					}
+				default:
+					drained = true
+				}
+			}
+			cl.endCommands()
+			return
+		case <-cl.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stamps cmd and hands it to the writer. Returns false if the session was cancelled first.
+// The stall checks go on while waiting: a writer stuck on a server that stopped reading is
+// the hung server StallTimeout() is there for.
+// NOTE: Only dispatch() may call this.
+func (cl *Client) pass(cmd Command) bool {
+	tick := cl.lastSeen()
+	if cl.opts.sent != nil {
+		cl.opts.sent(cmd, tick)
+	}
+	for {
+		select {
+		case cl.outgoing <- stampedCommand{cmd: cmd, tick: tick}:
+			return true
+		case now := <-cl.watchdogC():
+			cl.watch(now)
+		case <-cl.ctx.Done():
+			return false
+		}
+	}
+}
+
+// Hands a Ready() request to the writer, see pass.
+func (cl *Client) passReady(reply chan error) bool {
+	for {
+		select {
+		case cl.outgoing <- stampedCommand{ready: reply}:
+			return true
+		case now := <-cl.watchdogC():
+			cl.watch(now)
+		case <-cl.ctx.Done():
+			reply <- &Error{Kind: ErrWrite, Frame: "<ready>", Err: cl.ctx.Err()}
+			return false
+		}
+	}
+}
+
+// Takes commands from out until the commander closes it, so that a commander sending
+// after the session has ended doesn't block forever. Nothing is written anymore.
+func dropCommands(out <-chan Command) {
+	for range out {
+	}
+}
+
+// Tells the writer that no more commands are coming, it ends the session once they're written.
+// NOTE: Only dispatch() may call this, once.
+func (cl *Client) endCommands() {
+	cl.commandsEnded()
+	close(cl.outgoing)
+}
+
+// Calls the ended hook of Replay once no more commands are passed on: when Out() is closed,
+// or when dispatch() stops because the session was cancelled.
+// NOTE: Only dispatch() may call this.
+func (cl *Client) commandsEnded() {
+	if cl.opts.ended != nil && !cl.ended {
+		cl.ended = true
+		cl.opts.ended(cl.lastSeen())
+	}
+}
+
+// The struct sent through the old interface{} channel for ev, nil if there is none.
+func legacyMessage(ev Event) interface{} {
+	switch e := ev.(type) {
+	case *LevelLoaded:
+		return e.Level
+	case *GameStarted:
+		return e.Game
+	case *Tick:
+		return e.Game
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NOTE: This file contains the fan-out of one session to several commanders sharing the team.
+
+// Fanout splits the team of one session between several sub-commanders, eg. offense and defense:
+//
+//	f, err := aisandbox.NewFanout(client)
+//	offense, err := f.Add("offense", "Blue0", "Blue1")
+//	defense, err := f.Add("defense", "Blue2", "Blue3")
+//	f.Start()
+//	go runOffense(offense)
+//	go runDefense(defense)
+//
+// Every sub-commander receives every event of the session. The GameInfo structs are shared,
+// so they must not be modified.
+//
+// Commands are checked against bot ownership before they're sent: an order for a bot owned by
+// another sub-commander is dropped. Bots nobody owns can be ordered by anyone, but only by one
+// sub-commander between two ticks, orders from the others are dropped.
+// Every dropped order is reported as ErrConflict on Client.Errors() and as a Conflict event to the sub-commander that sent it.
+type Fanout struct {
+	cl   *Client
+	subs []*SubCommander
+
+	mu      sync.Mutex
+	owners  map[string]*SubCommander // bot -> owner
+	claimed map[string]*SubCommander // unowned bot -> sub-commander that ordered it since the latest tick
+
+	readyOnce sync.Once
+	started   bool
+}
+
+// SubCommander is one commander of a Fanout, used like a Client with Events(), Out() and Ready().
+type SubCommander struct {
+	Name string
+
+	f      *Fanout
+	feed   chan Event // from the fan-out
+	events chan Event
+	out    chan Command
+	ready  bool // guarded by f.mu
+}
+
+// Sent to a sub-commander when its order was dropped. Cmd is the dropped command.
+type Conflict struct {
+	Bot   string
+	Cmd   Command
+	Owner string // name of the sub-commander that owns or already ordered the bot
+}
+
+func (*Conflict) isEvent() {}
+
+func (c *Conflict) Error() string {
+	return fmt.Sprintf("%s is commanded by %s", c.Bot, c.Owner)
+}
+
+// Shares the session of cl. Don't use cl.Events(), cl.In() or cl.Out() once Start() is called.
+// Returns an error if cl was opened with ReuseGameInfo(), the sub-commanders read the shared GameInfos at their own pace.
+func NewFanout(cl *Client) (*Fanout, error) {
+	if cl.opts != nil && cl.opts.reuse {
+		return nil, errors.New("aisandbox: Fanout can't share a session opened with ReuseGameInfo()")
+	}
+	return &Fanout{
+		cl:      cl,
+		owners:  make(map[string]*SubCommander),
+		claimed: make(map[string]*SubCommander),
+	}, nil
+}
+
+// Adds a sub-commander that owns bots. More can be given later with Own.
+// NOTE: Must be called before Start. Returns a *Conflict if one of bots is owned already, nothing is added then.
+func (f *Fanout) Add(name string, bots ...string) (*SubCommander, error) {
+	if f.started {
+		return nil, errors.New("aisandbox: Fanout.Add called after Start")
+	}
+	f.mu.Lock()
+	for _, bot := range bots {
+		if owner, ok := f.owners[bot]; ok {
+			f.mu.Unlock()
+			return nil, &Conflict{Bot: bot, Owner: owner.Name}
+		}
+	}
+	f.mu.Unlock()
+
+	s := &SubCommander{
+		Name:   name,
+		f:      f,
+		feed:   make(chan Event),
+		events: make(chan Event),
+		out:    make(chan Command),
+	}
+	s.Own(bots...)
+	f.subs = append(f.subs, s)
+	return s, nil
+}
+
+// Starts delivering events to the sub-commanders.
+// Out() of the session is closed once every sub-commander has closed its Out().
+func (f *Fanout) Start() {
+	f.started = true
+	events := f.cl.Events()
+
+	var wg sync.WaitGroup
+	wg.Add(len(f.subs))
+	for _, s := range f.subs {
+		go func(s *SubCommander) {
+			defer wg.Done()
+			s.pump()
+		}(s)
+	}
+	go func() {
+		for ev := range events {
+			if _, ok := ev.(*Tick); ok {
+				f.mu.Lock()
+				f.claimed = make(map[string]*SubCommander)
+				f.mu.Unlock()
+			}
+			for _, s := range f.subs {
+				s.feed <- ev
+			}
+		}
+		for _, s := range f.subs {
+			close(s.feed)
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(f.cl.Out())
+	}()
+}
+
+// Events of the session, closed after the last one like Client.Events().
+// Also carries Conflict events for the orders of this sub-commander that were dropped.
+func (s *SubCommander) Events() <-chan Event {
+	return s.events
+}
+
+// Commands of this sub-commander. Close it when done.
+func (s *SubCommander) Out() chan<- Command {
+	return s.out
+}
+
+// Takes the ownership of bots, eg. after seeing Team.Members in GameStarted.
+// Returns a *Conflict if one of them is owned by another sub-commander, the rest are taken anyway.
+func (s *SubCommander) Own(bots ...string) error {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	var err error
+	for _, bot := range bots {
+		if owner, ok := s.f.owners[bot]; ok && owner != s {
+			err = &Conflict{Bot: bot, Owner: owner.Name}
+			continue
+		}
+		s.f.owners[bot] = s
+	}
+	return err
+}
+
+// Marks this sub-commander ready. Ready is sent to the server once every sub-commander has called it,
+// the error is the one from Client.Ready.
+func (s *SubCommander) Ready() error {
+	s.f.mu.Lock()
+	s.ready = true
+	for _, other := range s.f.subs {
+		if !other.ready {
+			s.f.mu.Unlock()
+			return nil
+		}
+	}
+	s.f.mu.Unlock()
+
+	var err error
+	s.f.readyOnce.Do(func() {
+		err = s.f.cl.Ready()
+	})
+	return err
+}
+
+// Hands events to the sub-commander and checks its commands, until both the events
+// have been delivered and Out() is closed.
+func (s *SubCommander) pump() {
+	var (
+		queue  []Event
+		feed   = s.feed
+		out    = s.out
+		events = s.events
+	)
+	for feed != nil || len(queue) > 0 || out != nil {
+		var (
+			send chan Event
+			next Event
+		)
+		if len(queue) > 0 {
+			send, next = events, queue[0]
+		}
+		select {
+		case ev, ok := <-feed:
+			if !ok {
+				feed = nil
+				break
+			}
+			queue = append(queue, ev)
+		case send <- next:
+			queue = queue[1:]
+		case cmd, ok := <-out:
+			if !ok {
+				out = nil
+				break
+			}
+			cmd, conflicts := s.f.check(s, cmd)
+			for _, c := range conflicts {
+				if events != nil {
+					queue = append(queue, c)
+				}
+			}
+			if cmd != nil {
+				s.f.cl.Out() <- cmd
+			}
+		}
+		if feed == nil && len(queue) == 0 && events != nil {
+			close(events)
+			events = nil
+		}
+	}
+}
+
+// Drops the orders of cmd that s isn't allowed to give, returns what's left of it.
+// What's left of a Batch is returned as a flat Batch.
+func (f *Fanout) check(s *SubCommander, cmd Command) (Command, []*Conflict) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var conflicts []*Conflict
+	allowed := func(cmd Command) bool {
+		bot, ok := commandBot(cmd)
+		if !ok {
+			return true
+		}
+		owner, owned := f.owners[bot]
+		if !owned {
+			if owner = f.claimed[bot]; owner == nil {
+				f.claimed[bot] = s
+				return true
+			}
+		}
+		if owner == s {
+			return true
+		}
+		c := &Conflict{Bot: bot, Cmd: cmd, Owner: owner.Name}
+		f.cl.report(&Error{Kind: ErrConflict, Frame: "<command>", Err: fmt.Errorf("%s: %w", s.Name, c)})
+		conflicts = append(conflicts, c)
+		return false
+	}
+
+	if _, ok := cmd.(Batch); !ok {
+		if allowed(cmd) {
+			return cmd, nil
+		}
+		return nil, conflicts
+	}
+	// Batches are unpacked all the way down, the same way they're written.
+	var (
+		left Batch
+		keep func(cmd Command)
+	)
+	keep = func(cmd Command) {
+		if batch, ok := cmd.(Batch); ok {
+			for _, v := range batch {
+				keep(v)
+			}
+		} else if allowed(cmd) {
+			left = append(left, cmd)
+		}
+	}
+	keep(cmd)
+	if len(left) == 0 {
+		return nil, conflicts
+	}
+	return left, conflicts
+}
+
+// Bot the command is for, ok = false for commands these bindings don't know.
+func commandBot(cmd Command) (bot string, ok bool) {
+	switch cmd := cmd.(type) {
+	case *Defend:
+		return cmd.Bot, true
+	case *Move:
+		return cmd.Bot, true
+	case *Attack:
+		return cmd.Bot, true
+	case *Charge:
+		return cmd.Bot, true
+	}
+	return "", false
+}
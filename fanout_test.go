@@ -0,0 +1,215 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanout(t *testing.T) {
+	server, client := net.Pipe()
+	ordered := make(chan []string, 1)
+	go func() {
+		defer server.Close()
+		r := serveInit(server)
+		if frame, err := ReadFrame(r); err != nil || frame.Header != "<ready>" {
+			t.Errorf("Expected <ready>, got %q (%v)", frame.Raw, err)
+		}
+		server.Write([]byte(json_tick))
+
+		var bots []string
+		for len(bots) < 3 {
+			frame, err := ReadFrame(r)
+			if err != nil {
+				t.Errorf(err.Error())
+				break
+			}
+			cmd, err := ParseCommand(frame.Payload[0])
+			if err != nil {
+				t.Errorf(err.Error())
+				break
+			}
+			bot, _ := commandBot(cmd)
+			bots = append(bots, bot)
+		}
+		server.Write([]byte(json_shutdown))
+		ordered <- bots
+	}()
+
+	cl := Open(context.Background(), client, "Split")
+	f, err := NewFanout(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offense, _ := f.Add("offense", "A")
+	defense, _ := f.Add("defense", "B")
+	f.Start()
+
+	conflicts := make(chan *Conflict, 2)
+	offenseDone := make(chan struct{})
+	run := func(s *SubCommander, orders func()) {
+		defer close(s.Out())
+		for ev := range s.Events() {
+			switch ev := ev.(type) {
+			case *GameStarted:
+				if err := s.Ready(); err != nil {
+					t.Errorf(err.Error())
+				}
+			case *Tick:
+				orders()
+			case *Conflict:
+				conflicts <- ev
+				if s == offense {
+					close(offenseDone)
+				}
+			}
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(defense, func() {
+			// C was already ordered by offense during this tick.
+			<-offenseDone
+			defense.Out() <- NewMove("B", "", []float64{1, 1})
+			defense.Out() <- NewMove("C", "", []float64{1, 1})
+		})
+	}()
+	run(offense, func() {
+		offense.Out() <- Batch{NewMove("A", "", []float64{1, 1}), NewMove("B", "", []float64{1, 1}), NewMove("C", "", []float64{1, 1})}
+	})
+	<-done
+	cl.Close()
+
+	bots := <-ordered
+	sort.Strings(bots)
+	if strings.Join(bots, ",") != "A,B,C" {
+		t.Errorf("Expected orders for A, B and C, got %v", bots)
+	}
+	for _, expected := range []string{"B is commanded by defense", "C is commanded by offense"} {
+		if c := <-conflicts; c.Error() != expected || c.Cmd == nil {
+			t.Errorf("Expected conflict %q, got %q", expected, c)
+		}
+	}
+	var reported int
+	for err := range cl.Errors() {
+		if errors.Is(err, ErrConflict) {
+			reported++
+		}
+	}
+	if reported != 2 {
+		t.Errorf("Expected 2 conflicts on Errors(), got %d", reported)
+	}
+}
+
+func TestFanoutNestedBatch(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+	cl := Open(context.Background(), client, "Split")
+	defer cl.Close()
+
+	f, err := NewFanout(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add("offense", "Blue0")
+	defense, _ := f.Add("defense", "Blue1")
+	cmd, conflicts := f.check(defense, Batch{Batch{NewMove("Blue0", "", Vec2{1, 1})}, NewMove("Blue1", "", Vec2{1, 1})})
+	if len(conflicts) != 1 || conflicts[0].Bot != "Blue0" {
+		t.Errorf("Expected a conflict over Blue0, got %v", conflicts)
+	}
+	if batch, ok := cmd.(Batch); !ok || len(batch) != 1 {
+		t.Fatalf("Expected a Batch with the order for Blue1, got %#v", cmd)
+	} else if bot, _ := commandBot(batch[0]); bot != "Blue1" {
+		t.Errorf("Expected the order for Blue1, got %v", bot)
+	}
+	if cmd, conflicts := f.check(defense, Batch{Batch{NewMove("Blue0", "", Vec2{1, 1})}}); cmd != nil || len(conflicts) != 1 {
+		t.Errorf("Expected the nested order for Blue0 to be dropped, got %#v, %v", cmd, conflicts)
+	}
+}
+
+func TestFanoutReuse(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+	cl := Open(context.Background(), client, "Frugal", ReuseGameInfo())
+	defer cl.Close()
+
+	if f, err := NewFanout(cl); err == nil || f != nil {
+		t.Errorf("Expected NewFanout to fail with ReuseGameInfo(), got %v, %v", f, err)
+	}
+}
+
+func TestFanoutAdd(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+	cl := Open(context.Background(), client, "Twice")
+	defer cl.Close()
+
+	f, err := NewFanout(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Add("offense", "A", "B"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.Add("defense", "C", "B")
+	if c, ok := err.(*Conflict); !ok || c.Bot != "B" || c.Owner != "offense" || s != nil {
+		t.Errorf("Expected a Conflict over B with offense, got %v, %v", s, err)
+	}
+	if s, err := f.Add("defense", "C"); err != nil || len(f.subs) != 2 {
+		t.Errorf("Expected C to be free after the failed Add, got %v, %v", s, err)
+	}
+
+	f.Start()
+	if s, err := f.Add("late", "D"); err == nil || s != nil {
+		t.Errorf("Expected Add after Start to fail, got %v, %v", s, err)
+	}
+}
+
+func TestFanoutClosed(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		go io.Copy(io.Discard, serveInit(server))
+	}()
+
+	cl := Open(context.Background(), client, "Quitter")
+	f, err := NewFanout(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := f.Add("only", "Blue0")
+	f.Start()
+	for ev := range s.Events() {
+		if _, ok := ev.(*GameStarted); ok {
+			break
+		}
+	}
+	cl.Close()
+
+	// The second send only gets through once the first one was handled.
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		s.Out() <- NewMove("Blue0", "", Vec2{1, 1})
+		s.Out() <- NewMove("Blue0", "", Vec2{2, 2})
+		close(s.Out())
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Errorf("Expected commands after Close to be dropped, the fan-out blocked")
+	}
+}
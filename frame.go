@@ -0,0 +1,67 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bufio"
+	"strings"
+)
+
+// NOTE: This file contains the framing of the protocol, shared by the client, recorder, replay and tools.
+
+// Number of JSON lines that follow each header.
+var framePayloads = map[string]int{
+	"<connect>":    1,
+	"<initialize>": 2,
+	"<tick>":       1,
+	"<shutdown>":   0,
+	"<command>":    1,
+	"<ready>":      0,
+}
+
+// Frame is one message of the protocol: a header line such as "<tick>" followed by its JSON lines.
+type Frame struct {
+	Header  string   // eg. "<tick>", surrounding whitespace removed. Empty for a blank line.
+	Payload [][]byte // JSON lines, trailing newline included
+	Raw     []byte   // the frame exactly as it was read, header line included
+}
+
+// Reads one frame from r. Headers that aren't part of the protocol are returned without payload.
+// On error the frame read so far is returned along with the error.
+func ReadFrame(r *bufio.Reader) (*Frame, error) {
+	line, err := r.ReadBytes('\n')
+	f := &Frame{
+		Header: strings.TrimSpace(string(line)),
+		Raw:    line,
+	}
+	if err != nil {
+		return f, err
+	}
+
+	for i := 0; i < framePayloads[f.Header]; i++ {
+		if line, err = r.ReadBytes('\n'); err != nil {
+			f.Raw = append(f.Raw, line...)
+			return f, err
+		}
+		f.Payload = append(f.Payload, line)
+		f.Raw = append(f.Raw, line...)
+	}
+	return f, nil
+}
+
+// Builds a frame from header and payload lines. Payloads without a trailing newline get one.
+func NewFrame(header string, payloads ...[]byte) *Frame {
+	f := &Frame{Header: header}
+	f.Raw = append(f.Raw, header...)
+	f.Raw = append(f.Raw, '\n')
+	for _, b := range payloads {
+		if len(b) == 0 || b[len(b)-1] != '\n' {
+			b = append(b[:len(b):len(b)], '\n')
+		}
+		f.Payload = append(f.Payload, b)
+		f.Raw = append(f.Raw, b...)
+	}
+	return f
+}
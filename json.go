@@ -7,7 +7,10 @@ package aisandbox
 // NOTE: This file contains unexported things that are used when parsing JSON data from the game server.
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 )
 
 // Workaround for null JSON strings
@@ -45,128 +48,208 @@ type json_GameInfo struct {
 }
 
 // Parse json_GameInfo struct into more intuitive GameInfo struct
-// before sending it to the commander
-func (data *json_GameInfo) simplify() *GameInfo {
-	v := data.Value
-	own := v.Teams[v.Team].Value
-	enemy := v.Teams[v.EnemyTeam].Value
-	ownflag := v.Flags[own.Flag].Value
-	enemyflag := v.Flags[enemy.Flag].Value
-	match := v.Match.Value
-
-	// BotInfo
+// before sending it to the commander.
+// Parts that are missing or refer to something that doesn't exist are left out (or zero),
+// and listed in the returned error of type Inconsistencies. The GameInfo is usable either way.
+func (data *json_GameInfo) simplify() (*GameInfo, error) {
+	gi := new(GameInfo)
+	return gi, data.simplifyInto(gi, nil)
+}
 
-	ownbots := make(map[string]*BotInfo)
-	enemybots := make(map[string]*BotInfo)
-
-	for _, name := range own.Members {
-		ownbots[v.Bots[name].Value.Name] = &BotInfo{
-			Name:            v.Bots[name].Value.Name,
-			Team:            v.Bots[name].Value.Team,
-			Position:        v.Bots[name].Value.Position,
-			FacingDirection: v.Bots[name].Value.FacingDirection,
-			Flag:            string(v.Bots[name].Value.Flag),
-			State:           float64(v.Bots[name].Value.State),
-			Health:          float64(v.Bots[name].Value.Health),
-			SeenLast:        float64(v.Bots[name].Value.SeenLast),
-		}
+// Same as simplify, but fills gi. Without an arena everything is allocated anew
+// and the slices of data are shared, with an arena nothing of data is kept.
+func (data *json_GameInfo) simplifyInto(gi *GameInfo, a *arena) error {
+	a.reset()
+	var (
+		v        = &data.Value
+		problems Inconsistencies
+	)
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
 	}
 
-	for _, name := range enemy.Members {
-		enemybots[v.Bots[name].Value.Name] = &BotInfo{
-			Name:            v.Bots[name].Value.Name,
-			Team:            v.Bots[name].Value.Team,
-			Position:        v.Bots[name].Value.Position,
-			FacingDirection: v.Bots[name].Value.FacingDirection,
-			Flag:            string(v.Bots[name].Value.Flag),
-			State:           float64(v.Bots[name].Value.State),
-			Health:          float64(v.Bots[name].Value.Health),
-			SeenLast:        float64(v.Bots[name].Value.SeenLast),
+	team := func(name string) *json_TeamInfo {
+		if t := v.Teams[name]; t != nil {
+			return t
 		}
+		problem("team %q missing from teams", name)
+		t := new(json_TeamInfo)
+		t.Value.Name = name
+		return t
+	}
+	own := team(v.Team).Value
+	enemy := team(v.EnemyTeam).Value
 
-		for _, seenby := range v.Bots[name].Value.SeenBy {
-			enemybots[name].SeenBy = append(enemybots[name].SeenBy, ownbots[seenby])
+	flag := func(team, name string) *json_FlagInfo {
+		if f := v.Flags[name]; f != nil {
+			return f
 		}
+		problem("flag %q of team %q missing from flags", name, team)
+		return new(json_FlagInfo)
+	}
+	ownflag := flag(own.Name, own.Flag).Value
+	enemyflag := flag(enemy.Name, enemy.Flag).Value
 
-		for _, visible := range v.Bots[name].Value.VisibleEnemies {
-			enemybots[name].VisibleEnemies = append(enemybots[name].VisibleEnemies, ownbots[visible])
-		}
+	match := v.Match
+	if match == nil {
+		problem("match missing")
+		match = new(json_MatchInfo)
 	}
 
-	for _, name := range own.Members {
-		for _, seenby := range v.Bots[name].Value.SeenBy {
-			ownbots[name].SeenBy = append(ownbots[name].SeenBy, enemybots[seenby])
-		}
+	// BotInfo
 
-		for _, visible := range v.Bots[name].Value.VisibleEnemies {
-			ownbots[name].VisibleEnemies = append(ownbots[name].VisibleEnemies, enemybots[visible])
+	bots := func(i int, team string, members []string) map[string]*BotInfo {
+		infos := a.membersOf(i, len(members))
+		for _, name := range members {
+			bot := v.Bots[name]
+			if bot == nil {
+				problem("bot %q of team %q missing from bots", name, team)
+				continue
+			}
+			info := a.bot()
+			*info = BotInfo{
+				Name:            bot.Value.Name,
+				Team:            bot.Value.Team,
+				Position:        a.vec(bot.Value.Position),
+				FacingDirection: a.vec(bot.Value.FacingDirection),
+				Flag:            string(bot.Value.Flag),
+				State:           bot.Value.State,
+				Health:          float64(bot.Value.Health),
+				SeenLast:        float64(bot.Value.SeenLast),
+			}
+			infos[name] = info
 		}
+		return infos
 	}
+	ownbots := bots(0, own.Name, own.Members)
+	enemybots := bots(1, enemy.Name, enemy.Members)
+
+	// Links the bots that see each other, from members to the bots of the other team.
+	link := func(members []string, infos, others map[string]*BotInfo) {
+		for _, name := range members {
+			info := infos[name]
+			if info == nil {
+				continue
+			}
+			bot := &v.Bots[name].Value
+			info.SeenBy = a.linksFor(len(bot.SeenBy))
+			for _, seenby := range bot.SeenBy {
+				if other := others[seenby]; other != nil {
+					info.SeenBy = append(info.SeenBy, other)
+				} else {
+					problem("bot %q seen by unknown bot %q", name, seenby)
+				}
+			}
+			info.VisibleEnemies = a.linksFor(len(bot.VisibleEnemies))
+			for _, visible := range bot.VisibleEnemies {
+				if other := others[visible]; other != nil {
+					info.VisibleEnemies = append(info.VisibleEnemies, other)
+				} else {
+					problem("bot %q sees unknown bot %q", name, visible)
+				}
+			}
+			if len(info.SeenBy) == 0 {
+				info.SeenBy = nil
+			}
+			if len(info.VisibleEnemies) == 0 {
+				info.VisibleEnemies = nil
+			}
+		}
+	}
+	link(enemy.Members, enemybots, ownbots)
+	link(own.Members, ownbots, enemybots)
 
 	// FlagInfo
 
-	ownflaginfo := &FlagInfo{
-		Position:     ownflag.Position,
-		Carrier:      enemybots[string(ownflag.Carrier)],
+	carrier := func(flag string, carrier nstring, bots map[string]*BotInfo) *BotInfo {
+		if carrier == "" {
+			return nil
+		}
+		bot := bots[string(carrier)]
+		if bot == nil {
+			problem("flag %q carried by unknown bot %q", flag, carrier)
+		}
+		return bot
+	}
+
+	ownflaginfo := a.flag(0)
+	*ownflaginfo = FlagInfo{
+		Position:     a.vec(ownflag.Position),
+		Carrier:      carrier(own.Flag, ownflag.Carrier, enemybots),
 		RespawnTimer: ownflag.RespawnTimer,
 	}
 
-	enemyflaginfo := &FlagInfo{
-		Position:     enemyflag.Position,
-		Carrier:      ownbots[string(enemyflag.Carrier)],
+	enemyflaginfo := a.flag(1)
+	*enemyflaginfo = FlagInfo{
+		Position:     a.vec(enemyflag.Position),
+		Carrier:      carrier(enemy.Flag, enemyflag.Carrier, ownbots),
 		RespawnTimer: enemyflag.RespawnTimer,
 	}
 
 	// TeamInfo
 
-	ownteaminfo := &TeamInfo{
+	ownteaminfo := a.team(0)
+	*ownteaminfo = TeamInfo{
 		Name:              own.Name,
 		Flag:              ownflaginfo,
 		Members:           ownbots,
-		FlagSpawnLocation: own.FlagSpawnLocation,
-		FlagScoreLocation: own.FlagScoreLocation,
-		BotSpawnArea:      own.BotSpawnArea,
-		Score:             match.Scores[own.Name],
+		FlagSpawnLocation: a.vec(own.FlagSpawnLocation),
+		FlagScoreLocation: a.vec(own.FlagScoreLocation),
+		BotSpawnArea:      a.area(own.BotSpawnArea),
+		Score:             match.Value.Scores[own.Name],
 	}
 
-	enemyteaminfo := &TeamInfo{
+	enemyteaminfo := a.team(1)
+	*enemyteaminfo = TeamInfo{
 		Name:              enemy.Name,
 		Flag:              enemyflaginfo,
 		Members:           enemybots,
-		FlagSpawnLocation: enemy.FlagSpawnLocation,
-		FlagScoreLocation: enemy.FlagScoreLocation,
-		BotSpawnArea:      enemy.BotSpawnArea,
-		Score:             match.Scores[enemy.Name],
+		FlagSpawnLocation: a.vec(enemy.FlagSpawnLocation),
+		FlagScoreLocation: a.vec(enemy.FlagScoreLocation),
+		BotSpawnArea:      a.area(enemy.BotSpawnArea),
+		Score:             match.Value.Scores[enemy.Name],
 	}
 
 	// MatchInfo
 
-	matchinfo := &MatchInfo{
-		TimeRemaining:     match.TimeRemaining,
-		TimeToNextRespawn: match.TimeToNextRespawn,
-		TimePassed:        match.TimePassed,
+	matchinfo := a.matchInfo()
+	*matchinfo = MatchInfo{
+		TimeRemaining:     match.Value.TimeRemaining,
+		TimeToNextRespawn: match.Value.TimeToNextRespawn,
+		TimePassed:        match.Value.TimePassed,
 	}
 
 	// TODO: map instigator field to target?
-	for _, event := range match.CombatEvents {
-		matchinfo.CombatEvents = append(
-			matchinfo.CombatEvents,
-			&CombatEvent{
-				Type:       event.Value.Type,
-				Instigator: string(event.Value.Instigator),
-				Subject:    event.Value.Subject,
-				Time:       event.Value.Time,
-			},
-		)
+	matchinfo.CombatEvents = a.eventsFor(len(match.Value.CombatEvents))
+	for i, event := range match.Value.CombatEvents {
+		if event == nil || event.Value == nil {
+			problem("combat event %d empty", i)
+			continue
+		}
+		ev := a.event()
+		*ev = CombatEvent{
+			Type:       event.Value.Type,
+			Instigator: string(event.Value.Instigator),
+			Subject:    event.Value.Subject,
+			Time:       event.Value.Time,
+		}
+		matchinfo.CombatEvents = append(matchinfo.CombatEvents, ev)
+	}
+	if len(matchinfo.CombatEvents) == 0 {
+		matchinfo.CombatEvents = nil
 	}
 
 	// GameInfo
 
-	return &GameInfo{
+	*gi = GameInfo{
 		Team:      ownteaminfo,
 		EnemyTeam: enemyteaminfo,
 		Match:     matchinfo,
 	}
+	if problems != nil {
+		return problems
+	}
+	return nil
 }
 
 type json_LevelInfo struct {
@@ -192,7 +275,7 @@ type json_FlagInfo struct {
 		Name         string    `json:"name"`
 		Team         string    `json:"team"`
 		Position     []float64 `json:"position"`
-		Carrier      nstring   `json:"carrier, omitempty"` // optional bot name, null if the flag is not being carried
+		Carrier      nstring   `json:"carrier,omitempty"` // optional bot name, null if the flag is not being carried
 		RespawnTimer float64   `json:"respawnTimer"`
 	} `json:"__value__"`
 }
@@ -202,15 +285,15 @@ type json_BotInfo struct {
 	Value struct {
 		Name            string    `json:"name"`
 		Team            string    `json:"team"`
-		Position        []float64 `json:"position, omitempty"`        // optional, null if the bot is not visible
-		FacingDirection []float64 `json:"facingDirection, omitempty"` // optional, null if the bot is not visible
-		Flag            nstring   `json:"flag, omitempty"`            // optional flag name, null if the bot is not carrying a flag
+		Position        []float64 `json:"position,omitempty"`        // optional, null if the bot is not visible
+		FacingDirection []float64 `json:"facingDirection,omitempty"` // optional, null if the bot is not visible
+		Flag            nstring   `json:"flag,omitempty"`            // optional flag name, null if the bot is not carrying a flag
 		// values are 0 = unknown, 1 = idle, 2 = defending, 3 = moving, 4 = attacking, 5 = charging, 6 = shooting
-		State          nfloat64 `json:"state, omitempty"`    // optional current action name, null if the bot is not visible
-		Health         nfloat64 `json:"health, omitempty"`   // optional, null if the bot is not visible
-		SeenLast       nfloat64 `json:"seenlast, omitempty"` // time since the object was last seen, null if the object was never seen
-		VisibleEnemies []string `json:"visibleEnemies"`      // list of bot names for bots which this bot can see
-		SeenBy         []string `json:"seenBy"`              // list of bot names for bots which can see this bot
+		State          BotState `json:"state,omitempty"`    // optional current action name, null if the bot is not visible
+		Health         nfloat64 `json:"health,omitempty"`   // optional, null if the bot is not visible
+		SeenLast       nfloat64 `json:"seenlast,omitempty"` // time since the object was last seen, null if the object was never seen
+		VisibleEnemies []string `json:"visibleEnemies"`     // list of bot names for bots which this bot can see
+		SeenBy         []string `json:"seenBy"`             // list of bot names for bots which can see this bot
 	} `json:"__value__"`
 }
 
@@ -231,8 +314,8 @@ type json_MatchCombatEvent struct {
 }
 
 type json_CombatEvent struct {
-	Type       float64 `json:"type"`                  // values are 0 = none, 1 = bot killed, 2 = flag picked up, 3 = flag dropped (more to be added soon)
-	Instigator nstring `json:"instigator, omitempty"` // optional bot name that caused the event, null if the event was automatic (eg flag reset, bot respawn)
+	Type       EventType `json:"type"`                 // values are 0 = none, 1 = bot killed, 2 = flag picked up, 3 = flag dropped (more to be added soon)
+	Instigator nstring   `json:"instigator,omitempty"` // optional bot name that caused the event, null if the event was automatic (eg flag reset, bot respawn)
 	// can either be a FlagInfo or a BotInfo name
 	Subject string  `json:"subject"` // bot or flag name that was the subject of the event
 	Time    float64 `json:"time"`
@@ -252,3 +335,61 @@ type json_ClientConnect struct {
 		Language      string `json:"language"`
 	} `json:"__value__"`
 }
+
+type json_Command struct {
+	Class string      `json:"__class__"`
+	Value interface{} `json:"__value__"`
+}
+
+// Protocol version 1.4, the structs above describe its wire format.
+type codec14 struct{}
+
+func (codec14) Version() string {
+	return "1.4"
+}
+
+func (codec14) DecodeLevelInfo(b []byte) (*LevelInfo, error) {
+	li := new(json_LevelInfo)
+	if err := json.Unmarshal(b, li); err != nil {
+		return nil, err
+	}
+	if li.Value == nil {
+		return nil, errors.New("LevelInfo missing")
+	}
+	return li.Value, nil
+}
+
+func (codec14) DecodeGameInfo(b []byte) (*GameInfo, error) {
+	gi := new(json_GameInfo)
+	if err := json.Unmarshal(b, gi); err != nil {
+		return nil, err
+	}
+	return gi.simplify()
+}
+
+func (codec14) EncodeCommand(cmd Command) ([]byte, error) {
+	var class string
+	switch cmd.(type) {
+	case *Defend:
+		class = "Defend"
+	case *Move:
+		class = "Move"
+	case *Attack:
+		class = "Attack"
+	case *Charge:
+		class = "Charge"
+	default:
+		// Custom command, trust it to know its own format
+		b := cmd.JSON()
+		if len(bytes.TrimSpace(b)) == 0 {
+			return nil, fmt.Errorf("%T has no JSON", cmd)
+		}
+		return b, nil
+	}
+
+	b, err := json.Marshal(json_Command{Class: class, Value: cmd})
+	if err != nil {
+		return nil, err
+	}
+	return trim(b), nil
+}
@@ -0,0 +1,146 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"sync"
+	"time"
+)
+
+// NOTE: This file contains the tracking of how long the commander takes to answer a tick.
+
+const (
+	// Number of ticks the rolling statistics are calculated from
+	LATENCY_WINDOW = 256
+)
+
+// Upper bounds of the histogram buckets, the last bucket collects everything slower.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	time.Millisecond * 2,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 20,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 200,
+	time.Millisecond * 500,
+	time.Second,
+}
+
+// Decision latency is the time from reading a <tick> to writing the first commands the commander sent
+// after receiving it, even if the commander has fallen behind and later ticks were read in the meantime.
+// Ticks that the commander doesn't answer are not counted.
+type LatencyStats struct {
+	Ticks     int             // ticks answered during the whole session
+	Last      time.Duration   // latency of the latest answered tick
+	Mean      time.Duration   // over the last LATENCY_WINDOW answered ticks
+	Max       time.Duration   // over the last LATENCY_WINDOW answered ticks
+	Histogram []LatencyBucket // over the last LATENCY_WINDOW answered ticks
+}
+
+type LatencyBucket struct {
+	Max   time.Duration // upper bound of the bucket, 0 for the last one that has no limit
+	Count int
+}
+
+// Sent when answering a tick took longer than the budget set with Budget().
+// NOTE: Delivered just before the next event from the server.
+type BudgetExceeded struct {
+	Latency time.Duration
+	Budget  time.Duration
+}
+
+func (*BudgetExceeded) isEvent() {}
+
+// Warn with a BudgetExceeded event whenever the commander takes longer than d to answer a tick.
+func Budget(d time.Duration) Option {
+	return func(o *options) {
+		o.budget = d
+	}
+}
+
+type latencyTracker struct {
+	mu     sync.Mutex
+	reads  map[int]time.Time // GameInfo index -> when its <tick> was read, until it's answered
+	ticks  int
+	window [LATENCY_WINDOW]time.Duration
+	pos    int
+}
+
+// Called by the reader when the <tick> carrying the GameInfo with index tick is read, see lastSeen.
+func (t *latencyTracker) frame(tick int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reads == nil {
+		t.reads = make(map[int]time.Time)
+	}
+	t.reads[tick] = at
+	// A tick this old is never going to be answered.
+	delete(t.reads, tick-LATENCY_WINDOW)
+}
+
+// Called by the writer after commands stamped with tick were written.
+// Returns the latency and true if these were the first commands answering that <tick>.
+// Older ticks can't be answered anymore once the commander has answered a newer one.
+func (t *latencyTracker) command(tick int, at time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	read, ok := t.reads[tick]
+	if !ok {
+		return 0, false
+	}
+	for i := range t.reads {
+		if i <= tick {
+			delete(t.reads, i)
+		}
+	}
+	latency := at.Sub(read)
+	t.window[t.pos%LATENCY_WINDOW] = latency
+	t.pos++
+	t.ticks++
+	return latency, true
+}
+
+func (t *latencyTracker) stats() LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := LatencyStats{
+		Ticks:     t.ticks,
+		Histogram: make([]LatencyBucket, len(latencyBuckets)+1),
+	}
+	for i, max := range latencyBuckets {
+		stats.Histogram[i].Max = max
+	}
+	if t.ticks == 0 {
+		return stats
+	}
+	stats.Last = t.window[(t.pos-1)%LATENCY_WINDOW]
+
+	n := t.pos
+	if n > LATENCY_WINDOW {
+		n = LATENCY_WINDOW
+	}
+	var total time.Duration
+	for _, latency := range t.window[:n] {
+		total += latency
+		if latency > stats.Max {
+			stats.Max = latency
+		}
+		i := 0
+		for i < len(latencyBuckets) && latency > latencyBuckets[i] {
+			i++
+		}
+		stats.Histogram[i].Count++
+	}
+	stats.Mean = total / time.Duration(n)
+	return stats
+}
+
+// Decision latency of the commander so far.
+func (cl *Client) Latency() LatencyStats {
+	return cl.latency.stats()
+}
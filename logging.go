@@ -0,0 +1,83 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// NOTE: This file contains the logging of the session.
+
+// Send the diagnostics of the session to l instead of throwing them away.
+// Every record has the commander name as "session", records about a frame also have
+// "frame" (eg. "<tick>") and "gameTime" (MatchInfo.TimePassed of the latest tick).
+//
+// Errors are logged at slog.LevelWarn, or slog.LevelError when they end the session,
+// handshake and the end of the session at slog.LevelInfo and the rest at slog.LevelDebug.
+// NOTE: Errors are delivered on Errors() whether they're logged or not.
+func Logger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// Default logger, keeps the library quiet.
+var quiet = slog.New(discard{})
+
+// slog.Handler that is never enabled, so nothing is even formatted.
+type discard struct{}
+
+func (discard) Enabled(context.Context, slog.Level) bool  { return false }
+func (discard) Handle(context.Context, slog.Record) error { return nil }
+func (d discard) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discard) WithGroup(string) slog.Handler           { return d }
+
+// Logs msg with the session, frame and game time fields.
+func (cl *Client) logFrame(level slog.Level, frame, msg string, args ...interface{}) {
+	if !cl.logger.Enabled(context.Background(), level) {
+		return
+	}
+	args = append([]interface{}{"frame", frame, "gameTime", cl.metrics.lastTimePassed.Value()}, args...)
+	cl.logger.Log(context.Background(), level, msg, args...)
+}
+
+// Logs an error passed to report.
+func (cl *Client) logError(err error) {
+	var (
+		level = slog.LevelWarn
+		frame string
+		e     *Error
+	)
+	if errors.As(err, &e) {
+		frame = e.Frame
+	}
+	for _, fatal := range []error{ErrVersionMismatch, ErrEOF, ErrWrite, ErrStalled} {
+		if errors.Is(err, fatal) {
+			level = slog.LevelError
+		}
+	}
+	cl.logFrame(level, frame, err.Error())
+}
+
+// Records f if Record() is on, the session continues without recording if that fails.
+func (cl *Client) record(dir string, f *Frame, tick int) {
+	if err := cl.opts.recorder.record(dir, f, tick); err != nil {
+		cl.logFrame(slog.LevelWarn, f.Header, "Recording stopped", "err", err)
+	}
+}
+
+// Logs the end of the session, final is the last event sent to the commander.
+func (cl *Client) logEnd(final Event) {
+	switch ev := final.(type) {
+	case *Shutdown:
+		cl.logFrame(slog.LevelInfo, "<shutdown>", "Session ended", "reason", ev.Reason)
+	case *Disconnected:
+		cl.logFrame(slog.LevelInfo, "", "Session ended", "err", ev.Err)
+	default:
+		cl.logFrame(slog.LevelInfo, "", "Session ended")
+	}
+}
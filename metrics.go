@@ -0,0 +1,133 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// NOTE: This file contains the per session metrics published through expvar.
+
+// Publish the metrics of the session with expvar under name, eg. to scrape them from /debug/vars:
+//
+//	framesReceived  map of frame header to count, eg. {"<tick>": 1200}
+//	bytesRead       bytes read from the server
+//	bytesWritten    bytes written to the server
+//	decodeErrors    frames that couldn't be decoded
+//	commandsSent    map of command __class__ to count
+//	lastTimePassed  MatchInfo.TimePassed of the latest tick
+//	backlog         events waiting for the commander to take them
+//
+// A later session published under the same name replaces the earlier one.
+// NOTE: Panics if name is already used by something else than a session, like expvar.Publish does.
+func Metrics(name string) Option {
+	return func(o *options) {
+		o.metrics = name
+	}
+}
+
+// Metrics of the session, the same map that Metrics() option publishes. Nil if the client isn't connected.
+func (cl *Client) Metrics() *expvar.Map {
+	if cl.metrics == nil {
+		return nil
+	}
+	return cl.metrics.vars
+}
+
+type metrics struct {
+	vars           *expvar.Map
+	framesReceived *expvar.Map
+	commandsSent   *expvar.Map
+	bytesRead      *expvar.Int
+	bytesWritten   *expvar.Int
+	decodeErrors   *expvar.Int
+	lastTimePassed *expvar.Float
+	backlog        atomic.Int64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		vars:           new(expvar.Map).Init(),
+		framesReceived: new(expvar.Map).Init(),
+		commandsSent:   new(expvar.Map).Init(),
+		bytesRead:      new(expvar.Int),
+		bytesWritten:   new(expvar.Int),
+		decodeErrors:   new(expvar.Int),
+		lastTimePassed: new(expvar.Float),
+	}
+	m.vars.Set("framesReceived", m.framesReceived)
+	m.vars.Set("commandsSent", m.commandsSent)
+	m.vars.Set("bytesRead", m.bytesRead)
+	m.vars.Set("bytesWritten", m.bytesWritten)
+	m.vars.Set("decodeErrors", m.decodeErrors)
+	m.vars.Set("lastTimePassed", m.lastTimePassed)
+	m.vars.Set("backlog", expvar.Func(func() interface{} {
+		return m.backlog.Load()
+	}))
+	return m
+}
+
+// Sessions published with Metrics(), name -> map of the latest session.
+var (
+	published   = make(map[string]*atomic.Pointer[expvar.Map])
+	publishedMu sync.Mutex
+)
+
+func (m *metrics) publish(name string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	current, ok := published[name]
+	if !ok {
+		current = new(atomic.Pointer[expvar.Map])
+		published[name] = current
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			return json.RawMessage(current.Load().String())
+		}))
+	}
+	current.Store(m.vars)
+}
+
+// Name of the command as sent in __class__.
+func commandClass(cmd Command) string {
+	switch cmd.(type) {
+	case *Defend:
+		return "Defend"
+	case *Move:
+		return "Move"
+	case *Attack:
+		return "Attack"
+	case *Charge:
+		return "Charge"
+	}
+	return fmt.Sprintf("%T", cmd)
+}
+
+// Counts the bytes going through the connection.
+type countingReader struct {
+	r     io.Reader
+	count *expvar.Int
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *expvar.Int
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.count.Add(int64(n))
+	return n, err
+}
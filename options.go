@@ -0,0 +1,147 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// NOTE: This file contains the options that can be passed to ConnectContext and Open.
+
+// Option changes how a Client connects and behaves.
+type Option func(*options)
+
+type options struct {
+	dialer     Dialer
+	timeout    time.Duration // total time spent dialing, 0 = no limit
+	backoff    time.Duration // wait after the first failed attempt
+	maxBackoff time.Duration // upper limit for the wait between attempts
+	jitter     float64       // fraction of the wait that is randomized
+	attempts   int           // 0 = no limit
+
+	budget   time.Duration // see Budget()
+	recorder *recorder     // see Record()
+	realtime bool          // see RecordedSpeed()
+	linger   time.Duration // see CloseTimeout()
+	conflate bool          // see Conflate()
+	reuse    bool          // see ReuseGameInfo()
+	diffs    bool          // see Diffs()
+
+	autoReady   bool          // see AutoReady()
+	readyMargin time.Duration // see AutoReady()
+
+	stallTimeout time.Duration // see StallTimeout()
+	initTimeout  time.Duration // see StallTimeout()
+	abortOnStall bool          // see AbortOnStall()
+
+	metrics string       // see Metrics()
+	logger  *slog.Logger // see Logger()
+
+	// Called by dispatch() for every command before it's written, and once Out() is closed or the session
+	// is cancelled, with the latest GameInfo the commander received. Used by Replay.
+	sent  func(cmd Command, tick int)
+	ended func(tick int)
+}
+
+// Same behaviour as the original Connect: retry every 500ms for 10 seconds.
+func defaultOptions() *options {
+	return &options{
+		dialer:     NetDialer{},
+		timeout:    time.Second * 10,
+		backoff:    time.Millisecond * 500,
+		maxBackoff: time.Millisecond * 500,
+		linger:     time.Second,
+		logger:     quiet,
+	}
+}
+
+// Total time allowed for dialing the server. Zero means that only the context limits dialing.
+func DialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Wait initial after the first failed attempt and double the wait after each
+// following failure, up to max.
+func Backoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.backoff = initial
+		o.maxBackoff = max
+		if max < initial {
+			o.maxBackoff = initial
+		}
+	}
+}
+
+// Randomize each wait by up to +-fraction of its length, eg. 0.2 for 20%.
+// Helps when a lot of bots are started at once against a slow server.
+func Jitter(fraction float64) Option {
+	return func(o *options) {
+		o.jitter = fraction
+	}
+}
+
+// Give up after n failed attempts. Zero means retry until timeout or cancellation.
+func Attempts(n int) Option {
+	return func(o *options) {
+		o.attempts = n
+	}
+}
+
+// Dial the server with d instead of TCP, eg. NetDialer{"unix"} for Unix domain sockets.
+func WithDialer(d Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// Time Close() gives the writer to send the commands that are still pending.
+// After that the connection is closed anyway, which unblocks a writer stuck on a server that doesn't read.
+// Close() waits for the session to end for another d after that, see Client.Close.
+func CloseTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.linger = d
+	}
+}
+
+// Dials addr until it succeeds or the retry policy gives up.
+func (o *options) dial(ctx context.Context, addr string) (conn io.ReadWriteCloser, err error) {
+	wait := o.backoff
+
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if conn, err = o.dialer.Dial(ctx, addr); err == nil {
+			return
+		}
+		if o.attempts > 0 && attempt >= o.attempts {
+			return
+		}
+
+		sleep := wait
+		if o.jitter > 0 {
+			sleep += time.Duration((rand.Float64()*2 - 1) * o.jitter * float64(wait))
+		}
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%v: %w", err, ctx.Err())
+		}
+
+		if wait *= 2; wait > o.maxBackoff {
+			wait = o.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// NOTE: This file contains the decoding of commands, the reverse of Command.JSON().
+
+// Wire form of a command, __value__ is decoded once the class is known.
+type json_RawCommand struct {
+	Class string          `json:"__class__"`
+	Value json.RawMessage `json:"__value__"`
+}
+
+// Reads a command in the format it's sent to the server, eg. {"__class__": "Move", "__value__": {...}}.
+// Defend is accepted in both 1.4 and 1.3 format.
+// Returns *Defend, *Move, *Attack or *Charge, an error for any other class.
+func ParseCommand(b []byte) (Command, error) {
+	raw := new(json_RawCommand)
+	if err := json.Unmarshal(b, raw); err != nil {
+		return nil, err
+	}
+
+	var cmd Command
+	switch raw.Class {
+	case "Defend":
+		cmd = new(Defend)
+	case "Move":
+		cmd = new(Move)
+	case "Attack":
+		cmd = new(Attack)
+	case "Charge":
+		cmd = new(Charge)
+	default:
+		return nil, fmt.Errorf("unknown command %q", raw.Class)
+	}
+	if err := json.Unmarshal(b, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Decodes b into v, which is the value of a command of class.
+// b is either the whole command with __class__ and __value__, or only the value.
+func unmarshalCommand(b []byte, class string, v interface{}) error {
+	raw := new(json_RawCommand)
+	if err := json.Unmarshal(b, raw); err != nil {
+		return err
+	}
+	switch raw.Class {
+	case "":
+		return json.Unmarshal(b, v)
+	case class:
+		return json.Unmarshal(raw.Value, v)
+	}
+	return fmt.Errorf("expected %s, got %q", class, raw.Class)
+}
+
+// Reads the [[x, y], duration] pair written by MarshalJSON.
+func (fd *FacingDirection) UnmarshalJSON(b []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return errors.New("Invalid FacingDirection, expected [[x, y], duration]")
+	}
+
+	var direction []float64
+	if err := json.Unmarshal(pair[0], &direction); err != nil {
+		return err
+	}
+	if len(direction) != 2 {
+		return errors.New("Invalid coordinates in FacingDirection")
+	}
+	fd.Direction = direction
+	return json.Unmarshal(pair[1], &fd.Duration)
+}
+
+// Accepts both 1.4 facingDirections and the single 1.3 facingDirection.
+func (c *Defend) UnmarshalJSON(b []byte) error {
+	type defend Defend // without the methods, so that decoding doesn't end up here again
+	value := struct {
+		*defend
+		FacingDirection []float64 `json:"facingDirection"` // 1.3
+	}{defend: (*defend)(c)}
+
+	if err := unmarshalCommand(b, "Defend", &value); err != nil {
+		return err
+	}
+	if c.FacingDirections == nil && value.FacingDirection != nil {
+		c.FacingDirections = []*FacingDirection{{Direction: value.FacingDirection}}
+	}
+	return nil
+}
+
+func (c *Move) UnmarshalJSON(b []byte) error {
+	type move Move
+	return unmarshalCommand(b, "Move", (*move)(c))
+}
+
+func (c *Attack) UnmarshalJSON(b []byte) error {
+	type attack Attack
+	return unmarshalCommand(b, "Attack", (*attack)(c))
+}
+
+func (c *Charge) UnmarshalJSON(b []byte) error {
+	type charge Charge
+	return unmarshalCommand(b, "Charge", (*charge)(c))
+}
@@ -0,0 +1,58 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	defend := &Defend{
+		Bot:         "Blue0",
+		Description: "hold",
+		FacingDirections: []*FacingDirection{
+			{Direction: []float64{1, 0.5}, Duration: 2},
+			{Direction: []float64{-1, 0}, Duration: 1.25},
+		},
+	}
+	commands := []Command{
+		defend,
+		&Move{Bot: "Blue1", Target: []Vec2{{1, 2}, {3, 4}}, Description: "go"},
+		&Attack{Bot: "Blue2", Target: []Vec2{{5, 6}}, LookAt: V(7, 8)},
+		&Charge{Bot: "Blue3", Target: []Vec2{{9, 10}}},
+	}
+	for _, cmd := range commands {
+		parsed, err := ParseCommand(cmd.JSON())
+		if err != nil {
+			t.Errorf("%T: %v", cmd, err)
+		} else if !reflect.DeepEqual(parsed, cmd) {
+			t.Errorf("%T: expected %+v, got %+v", cmd, cmd, parsed)
+		}
+	}
+
+	// Encoded by the codecs, 1.3 Defend keeps only the first direction.
+	b, _ := LookupCodec("1.3").EncodeCommand(defend)
+	parsed, err := ParseCommand(b)
+	if d, ok := parsed.(*Defend); err != nil || !ok || len(d.FacingDirections) != 1 || d.FacingDirections[0].Direction[0] != 1 {
+		t.Errorf("Expected 1.3 Defend with one direction, got %+v (%v)", parsed, err)
+	}
+
+	// Bare values decode as well, the wrong class doesn't.
+	move := new(Move)
+	if err = json.Unmarshal([]byte(`{"bot": "Blue0", "target": [[1, 1]]}`), move); err != nil || move.Bot != "Blue0" {
+		t.Errorf("Expected bare Move to decode, got %+v (%v)", move, err)
+	}
+	if err = json.Unmarshal(commands[1].JSON(), new(Charge)); err == nil {
+		t.Errorf("Expected an error decoding Move into Charge")
+	}
+	if _, err = ParseCommand([]byte(`{"__class__": "Dance", "__value__": {}}`)); err == nil {
+		t.Errorf("Expected an error for unknown command")
+	}
+	if _, err = ParseCommand([]byte(`{"__class__": "Defend", "__value__": {"bot": "Blue0", "facingDirections": [[[1], 1]]}}`)); err == nil {
+		t.Errorf("Expected an error for invalid FacingDirection")
+	}
+}
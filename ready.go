@@ -0,0 +1,95 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"time"
+)
+
+// NOTE: This file contains the session managed readiness, see Ready() and AutoReady().
+
+// Sent when AutoReady() told the server we're ready because the commander hadn't called Ready() in time.
+// NOTE: Delivered just before the next event from the server.
+type AutoReadySent struct {
+	After time.Duration // time from reading <initialize> to sending <ready>
+}
+
+// Sent right before the first Tick if the server started the game before Ready() was called,
+// ie. the commander used up all of LevelInfo.InitializationTime.
+type ReadyMissed struct {
+	InitializationTime time.Duration // time allowed by the server
+}
+
+func (*AutoReadySent) isEvent() {}
+func (*ReadyMissed) isEvent()   {}
+
+// Call Ready() on behalf of the commander margin before LevelInfo.InitializationTime runs out,
+// unless the commander called it already. An AutoReadySent event tells when that happened.
+func AutoReady(margin time.Duration) Option {
+	return func(o *options) {
+		o.autoReady = true
+		o.readyMargin = margin
+	}
+}
+
+// Sends <ready> unless it was sent already. If note isn't nil, it's queued for the commander
+// before the server can react to <ready>.
+// NOTE: Marked ready before writing, so that the reader can't mistake the first tick for ReadyMissed.
+func (cl *Client) ready(note Event) error {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	sent, err := cl.bufferReady()
+	if !sent || err != nil {
+		return err
+	}
+	if note != nil {
+		cl.queue(note)
+	}
+	if err = cl.flush("<ready>"); err != nil {
+		cl.readied.Store(false)
+	}
+	return err
+}
+
+// Buffers <ready> unless it was sent already. Returns sent = false if it had been.
+// NOTE: Must be called while holding wmu.
+func (cl *Client) bufferReady() (sent bool, err error) {
+	if cl.readied.Load() {
+		return false, nil
+	}
+	cl.readied.Store(true)
+	if err = cl.bufferFrame("<ready>", cl.lastSeen()); err != nil {
+		cl.readied.Store(false)
+	}
+	return true, err
+}
+
+// Called by the reader when the first <initialize> is read at the given time, later ones are ignored.
+// Starts the AutoReady() timer, which is stopped by stopAutoReady at the end of the session.
+func (cl *Client) initialized(at time.Time, level *LevelInfo) {
+	if !cl.opts.autoReady {
+		return
+	}
+	wait := seconds(level.InitializationTime) - cl.opts.readyMargin
+	if wait < 0 {
+		wait = 0
+	}
+	cl.readyTimer = time.AfterFunc(wait-time.Since(at), func() {
+		if err := cl.ready(&AutoReadySent{After: time.Since(at)}); err != nil {
+			cl.report(err)
+		}
+	})
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// NOTE: Only the reader may call this.
+func (cl *Client) stopAutoReady() {
+	if cl.readyTimer != nil {
+		cl.readyTimer.Stop()
+	}
+}
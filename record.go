@@ -0,0 +1,90 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// NOTE: This file contains the session recorder.
+
+// Directions of recorded frames
+const (
+	DIR_RECV = "recv" // from the server
+	DIR_SEND = "send" // to the server
+)
+
+// RecordEntry is one line of a recording: a single frame and when it was read or written.
+type RecordEntry struct {
+	Time  time.Time `json:"time"`
+	Dir   string    `json:"dir"`             // DIR_RECV or DIR_SEND
+	Frame string    `json:"frame"`           // header of the frame, eg. "<tick>"
+	Tick  int       `json:"tick"`            // latest GameInfo the commander had received, 0 is the one in <initialize>
+	Raw   string    `json:"raw,omitempty"`   // the frame byte-for-byte, header line and JSON lines included
+	Raw64 []byte    `json:"raw64,omitempty"` // used instead of Raw if the frame isn't valid UTF-8
+}
+
+// Entry for f read or written now, dir is DIR_RECV or DIR_SEND and tick as in RecordEntry.
+// Raw64 is used instead of Raw if f isn't valid UTF-8.
+func NewRecordEntry(dir string, f *Frame, tick int) *RecordEntry {
+	entry := &RecordEntry{
+		Time:  time.Now(),
+		Dir:   dir,
+		Frame: f.Header,
+		Tick:  tick,
+	}
+	if utf8.Valid(f.Raw) {
+		entry.Raw = string(f.Raw)
+	} else {
+		entry.Raw64 = f.Raw
+	}
+	return entry
+}
+
+// The recorded frame exactly as it was sent.
+func (e *RecordEntry) Bytes() []byte {
+	if e.Raw64 != nil {
+		return e.Raw64
+	}
+	return []byte(e.Raw)
+}
+
+// Record every frame read from and written to the server into w, one JSON object per line.
+// The recording is exact enough to replay the session byte-for-byte.
+//
+//	f, _ := os.Create("match.jsonl")
+//	defer f.Close()
+//	client, err := aisandbox.ConnectContext(ctx, addr, name, aisandbox.Record(f))
+func Record(w io.Writer) Option {
+	return func(o *options) {
+		o.recorder = &recorder{enc: json.NewEncoder(w)}
+	}
+}
+
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// Writes f into the recording. After the first failure recording stops, the session continues.
+// Returns the error only when it stops the recording.
+func (r *recorder) record(dir string, f *Frame, tick int) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil
+	}
+
+	r.err = r.enc.Encode(NewRecordEntry(dir, f, tick))
+	return r.err
+}
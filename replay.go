@@ -0,0 +1,224 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// NOTE: This file contains offline replay of recordings made with Record().
+
+// Replay frames with the delays they were recorded with instead of as fast as possible.
+func RecordedSpeed() Option {
+	return func(o *options) {
+		o.realtime = true
+	}
+}
+
+// Reads a recording made with Record().
+func ReadRecording(r io.Reader) ([]*RecordEntry, error) {
+	var entries []*RecordEntry
+	dec := json.NewDecoder(r)
+	for {
+		entry := new(RecordEntry)
+		if err := dec.Decode(entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Filled in by Replay once the commander closes Out() or the session ends otherwise, eg. by Close().
+// Read it after Close() of the replayed client has returned.
+type ReplayReport struct {
+	Ticks       int           // GameInfos handed to the commander
+	Divergences []*Divergence // ticks where the commander didn't send the recorded commands
+}
+
+// Commands sent after one GameInfo, as JSON.
+// Commands are compared without their order, since commanders often loop over maps.
+type Divergence struct {
+	Tick     int // 0 is the GameInfo of <initialize>, 1 the first <tick> and so on
+	Recorded []string
+	Replayed []string
+}
+
+// Feeds the recording at path to a commander as if it came from the server.
+// The client works like one returned by Open: In() and Out() are the same pair Connect returns,
+// Events(), Ready() and Close() work as usual.
+// Commands the commander sends are compared to the recorded ones, see ReplayReport.
+// The package level functions (Ready(), Errors() etc.) are left to the session of Connect.
+//
+// By default frames are replayed as fast as the commander reads them, use RecordedSpeed() option
+// to keep the recorded timing. Other options are applied to the replayed session as usual.
+func Replay(path string, opts ...Option) (*Client, *ReplayReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	entries, err := ReadRecording(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	r := &replay{
+		entries:  entries,
+		recorded: make(map[int][]string),
+		replayed: make(map[int][]string),
+		report:   new(ReplayReport),
+	}
+	r.index()
+	o.sent, o.ended = r.sent, r.compare
+
+	conn, feed := r.conn(o.realtime)
+	client := NewClient("Replay")
+	// The feed is one of the goroutines Close() waits for.
+	client.wg.Add(1)
+	client.start(context.Background(), conn, o)
+	go func() {
+		defer client.wg.Done()
+		feed(client.ctx)
+	}()
+
+	return client, r.report, nil
+}
+
+type replay struct {
+	entries  []*RecordEntry
+	recorded map[int][]string // tick index -> commands sent during the recorded session
+	replayed map[int][]string // tick index -> commands sent now
+	report   *ReplayReport
+	codec    Codec // the one the recorded session negotiated
+}
+
+// Sorts the recorded commands by the GameInfo the commander had received when sending them
+// and picks the codec the same way the session did at handshake.
+// NOTE: That's not the same as stream order: the server may have sent the next <tick> already.
+func (r *replay) index() {
+	r.codec = LookupCodec(api_version)
+	for _, entry := range r.entries {
+		frame, err := ReadFrame(bufio.NewReader(bytes.NewReader(entry.Bytes())))
+		if err != nil || len(frame.Payload) != 1 {
+			continue
+		}
+		switch {
+		case entry.Dir == DIR_RECV && entry.Frame == "<connect>":
+			connect := new(json_ConnectServer)
+			if json.Unmarshal(frame.Payload[0], connect) == nil {
+				if codec := LookupCodec(connect.Value.ProtocolVersion); codec != nil {
+					r.codec = codec
+				}
+			}
+		case entry.Dir == DIR_SEND && entry.Frame == "<command>":
+			r.recorded[entry.Tick] = append(r.recorded[entry.Tick], normalize(frame.Payload[0]))
+		}
+	}
+}
+
+// Connection that reads the received frames of the recording and throws away everything written to it.
+// feed has to be run in its own goroutine to push the frames, it stops early once ctx is done.
+func (r *replay) conn(realtime bool) (io.ReadWriteCloser, func(ctx context.Context)) {
+	pr, pw := io.Pipe()
+	feed := func(ctx context.Context) {
+		var last time.Time
+		for _, entry := range r.entries {
+			if entry.Dir != DIR_RECV {
+				continue
+			}
+			if realtime && !last.IsZero() {
+				timer := time.NewTimer(entry.Time.Sub(last))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					pw.Close()
+					return
+				}
+			}
+			last = entry.Time
+			if _, err := pw.Write(entry.Bytes()); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}
+	return &replayConn{pr}, feed
+}
+
+type replayConn struct {
+	*io.PipeReader
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Collects a command the commander sent after the GameInfo tick. Encoded with the codec
+// of the session, batches unpacked, the same way they end up in the recording.
+// NOTE: Called by the dispatching goroutine, which also calls compare once no more commands are passed on.
+func (r *replay) sent(cmd Command, tick int) {
+	if batch, ok := cmd.(Batch); ok {
+		for _, v := range batch {
+			r.sent(v, tick)
+		}
+		return
+	}
+	if b, err := r.codec.EncodeCommand(cmd); err == nil {
+		r.replayed[tick] = append(r.replayed[tick], normalize(b))
+	}
+}
+
+// Fills in the report for ticks 0..last.
+func (r *replay) compare(last int) {
+	r.report.Ticks = last + 1
+	for tick := 0; tick <= last; tick++ {
+		recorded, replayed := r.recorded[tick], r.replayed[tick]
+		sort.Strings(recorded)
+		sort.Strings(replayed)
+		if !equalStrings(recorded, replayed) {
+			r.report.Divergences = append(r.report.Divergences, &Divergence{
+				Tick:     tick,
+				Recorded: recorded,
+				Replayed: replayed,
+			})
+		}
+	}
+}
+
+// Compacts JSON so that formatting doesn't count as divergence.
+func normalize(b []byte) string {
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, b); err != nil {
+		return string(bytes.TrimSpace(b))
+	}
+	return buffer.String()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Simple deterministic commander: moves Blue0 to x on every GameInfo.
+func moveTo(in <-chan interface{}, out chan<- Command, ready func() error, x float64) {
+	for msg := range in {
+		if _, ok := msg.(*GameInfo); ok {
+			out <- NewMove("Blue0", "", []float64{x, 1})
+			ready()
+		}
+	}
+	close(out)
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "match.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	server, client := net.Pipe()
+	go func() {
+		// <initialize> after handshake, <tick> after <ready> and <shutdown> after the answer to the tick.
+		defer server.Close()
+		r := bufio.NewReader(server)
+		server.Write([]byte(json_connect))
+		ticked := false
+	loop:
+		for {
+			frame, err := ReadFrame(r)
+			if err != nil {
+				return
+			}
+			switch {
+			case frame.Header == "<connect>":
+				server.Write([]byte(json_init))
+			case frame.Header == "<ready>":
+				server.Write([]byte(json_tick))
+				ticked = true
+			case frame.Header == "<command>" && ticked:
+				server.Write([]byte(json_shutdown))
+				break loop
+			}
+		}
+		io.Copy(io.Discard, server)
+	}()
+	cl := Open(context.Background(), client, "Recorded", Record(f))
+	moveTo(cl.In(), cl.Out(), cl.Ready, 1)
+	// Wait for the session to end before closing the recording.
+	for range cl.Errors() {
+	}
+	f.Close()
+
+	connected := defaultClient
+	replay, report, err := Replay(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	moveTo(replay.In(), replay.Out(), replay.Ready, 1)
+	replay.Close()
+	if defaultClient != connected {
+		t.Errorf("Expected Replay to leave the client of Connect alone")
+	}
+	if report.Ticks != 2 || len(report.Divergences) != 0 {
+		t.Errorf("Expected 2 ticks without divergence, got %d ticks and %d divergences", report.Ticks, len(report.Divergences))
+		for _, d := range report.Divergences {
+			t.Logf("%d: %v / %v", d.Tick, d.Recorded, d.Replayed)
+		}
+	}
+
+	replay, report, err = Replay(path, RecordedSpeed())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	moveTo(replay.In(), replay.Out(), replay.Ready, 2)
+	replay.Close()
+	if len(report.Divergences) != 2 || report.Divergences[1].Tick != 1 {
+		t.Errorf("Expected divergence on both ticks, got %v", report.Divergences)
+	}
+
+	testReplayCancel(t, path)
+}
+
+// A replay that is cancelled before Out() is closed still fills in the report.
+func testReplayCancel(t *testing.T, path string) {
+	replay, report, err := Replay(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for msg := range replay.In() {
+		if _, ok := msg.(*GameInfo); ok {
+			break
+		}
+	}
+	replay.Out() <- NewMove("Blue0", "", []float64{2, 1})
+	replay.cancel()
+	replay.Close()
+	close(replay.Out())
+	if report.Ticks != 1 || len(report.Divergences) != 1 || report.Divergences[0].Tick != 0 {
+		t.Errorf("Expected divergence on the first of 1 ticks, got %d ticks and %v", report.Ticks, report.Divergences)
+	}
+}
+
+// Close doesn't wait for the next frame of a recording played at recorded speed.
+func TestReplayRecordedSpeedClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	start := time.Now()
+	enc := json.NewEncoder(f)
+	enc.Encode(&RecordEntry{Time: start, Dir: DIR_RECV, Frame: "<connect>", Raw: json_connect})
+	enc.Encode(&RecordEntry{Time: start, Dir: DIR_RECV, Frame: "<initialize>", Raw: json_init})
+	enc.Encode(&RecordEntry{Time: start.Add(time.Hour), Dir: DIR_RECV, Frame: "<tick>", Raw: json_tick})
+	f.Close()
+
+	baseline := runtime.NumGoroutine()
+	replay, _, err := Replay(path, RecordedSpeed())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for msg := range replay.In() {
+		if _, ok := msg.(*GameInfo); ok {
+			break
+		}
+	}
+	start = time.Now()
+	replay.Close()
+	close(replay.Out())
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected Close to stop waiting for the next frame, took %v", time.Since(start))
+	}
+	waitGoroutines(t, baseline)
+}
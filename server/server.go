@@ -0,0 +1,260 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+// Package server implements the server half of The AI Sandbox protocol,
+// for building test servers, simulators and tournament harnesses in Go.
+//
+//	l, _ := net.Listen("tcp", ":41041")
+//	srv := &server.Server{Listener: l, Version: "1.4"}
+//	conn, err := srv.Accept() // handshake done, conn.Name is the commander
+//	conn.Initialize(level, game)
+//	msg, err := conn.Read()   // wait for <ready>
+//	conn.Tick(game)           // typed or raw JSON, see Payload
+//	conn.Shutdown()
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/errnoh/aisandbox"
+)
+
+// NOTE: This file contains the connection handling and the framing of the server side.
+
+// Time a commander gets to complete the handshake when Server.HandshakeTimeout isn't set.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Server hands out commander connections that have completed the handshake.
+type Server struct {
+	Listener         net.Listener
+	Version          string        // protocol version sent in <connect>, eg. "1.4"
+	HandshakeTimeout time.Duration // 0 means DefaultHandshakeTimeout
+}
+
+// Waits for the next commander and does the handshake with it.
+// A commander that fails the handshake, or doesn't complete it within HandshakeTimeout,
+// is disconnected and its error returned. The listener keeps working so Accept can simply be called again.
+func (s *Server) Accept() (*Conn, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := Handshake(conn, s.Version)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// Conn is the server end of one commander connection. Writes are safe for concurrent use,
+// reads have to happen in one goroutine.
+type Conn struct {
+	Name     string // commanderName from ConnectClient
+	Language string // language from ConnectClient
+	Version  string // protocol version the connection was opened with
+
+	rw io.ReadWriteCloser
+	r  *bufio.Reader
+	mu sync.Mutex
+}
+
+// Wire format of the handshake messages.
+type connectServer struct {
+	Class string `json:"__class__"`
+	Value struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	} `json:"__value__"`
+}
+
+type connectClient struct {
+	Class string `json:"__class__"`
+	Value struct {
+		CommanderName string `json:"commanderName"`
+		Language      string `json:"language"`
+	} `json:"__value__"`
+}
+
+// Does the handshake over an already open connection, eg. one end of net.Pipe:
+// sends <connect> with version and validates the ConnectClient reply.
+// Errors are of type *aisandbox.Error.
+func Handshake(rw io.ReadWriteCloser, version string) (*Conn, error) {
+	c := &Conn{
+		Version: version,
+		rw:      rw,
+		r:       bufio.NewReader(rw),
+	}
+
+	hello := connectServer{Class: "ConnectServer"}
+	hello.Value.ProtocolVersion = version
+	if err := c.send("<connect>", hello); err != nil {
+		return nil, err
+	}
+
+	frame, err := aisandbox.ReadFrame(c.r)
+	if err != nil {
+		return nil, &aisandbox.Error{Kind: aisandbox.ErrEOF, Frame: frame.Header, Err: err}
+	}
+	if frame.Header != "<connect>" {
+		return nil, &aisandbox.Error{Kind: aisandbox.ErrUnexpected, Frame: frame.Header, Err: errors.New("waiting for <connect>")}
+	}
+	reply := new(connectClient)
+	if err = json.Unmarshal(frame.Payload[0], reply); err != nil {
+		return nil, &aisandbox.Error{Kind: aisandbox.ErrDecode, Frame: frame.Header, Err: err}
+	}
+	switch {
+	case reply.Class != "ConnectClient":
+		err = fmt.Errorf("expected ConnectClient, got %q", reply.Class)
+	case reply.Value.CommanderName == "":
+		err = errors.New("commanderName missing")
+	}
+	if err != nil {
+		return nil, &aisandbox.Error{Kind: aisandbox.ErrUnexpected, Frame: frame.Header, Err: err}
+	}
+
+	c.Name = reply.Value.CommanderName
+	c.Language = reply.Value.Language
+	return c, nil
+}
+
+// Sends <initialize> with the LevelInfo and the first GameInfo.
+// level and game are encoded as described in Payload.
+func (c *Conn) Initialize(level, game interface{}) error {
+	return c.send("<initialize>", level, game)
+}
+
+// Sends <tick> with game, encoded as described in Payload.
+func (c *Conn) Tick(game interface{}) error {
+	return c.send("<tick>", game)
+}
+
+// Sends <shutdown>. The connection stays open until Close, the commander may still send commands.
+func (c *Conn) Shutdown() error {
+	return c.send("<shutdown>")
+}
+
+// Closes the connection.
+func (c *Conn) Close() error {
+	return c.rw.Close()
+}
+
+// Message from the commander, either <ready> or <command>.
+type Message struct {
+	Ready   bool              // true for <ready>
+	Class   string            // __class__ of the command, eg. "Move"
+	Command aisandbox.Command // decoded with aisandbox.ParseCommand, nil for classes it doesn't know
+	Raw     json.RawMessage   // the command as it was sent, __class__ and __value__ included
+}
+
+// Reads the next message from the commander. Errors are of type *aisandbox.Error,
+// io.EOF is returned as such when the commander closes the connection between frames.
+func (c *Conn) Read() (*Message, error) {
+	for {
+		frame, err := aisandbox.ReadFrame(c.r)
+		if err == io.EOF && len(frame.Raw) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, &aisandbox.Error{Kind: aisandbox.ErrEOF, Frame: frame.Header, Err: err}
+		}
+
+		switch frame.Header {
+		case "":
+			continue
+		case "<ready>":
+			return &Message{Ready: true}, nil
+		case "<command>":
+			var class struct {
+				Class string `json:"__class__"`
+			}
+			if err = json.Unmarshal(frame.Payload[0], &class); err != nil {
+				return nil, &aisandbox.Error{Kind: aisandbox.ErrDecode, Frame: frame.Header, Err: err}
+			}
+			msg := &Message{
+				Class: class.Class,
+				Raw:   json.RawMessage(bytes.TrimSpace(frame.Payload[0])),
+			}
+			switch class.Class {
+			case "Defend", "Move", "Attack", "Charge":
+				if msg.Command, err = aisandbox.ParseCommand(msg.Raw); err != nil {
+					return nil, &aisandbox.Error{Kind: aisandbox.ErrDecode, Frame: frame.Header, Err: err}
+				}
+			}
+			return msg, nil
+		case "<connect>":
+			return nil, &aisandbox.Error{Kind: aisandbox.ErrUnexpected, Frame: frame.Header, Err: errors.New("already connected")}
+		default:
+			return nil, &aisandbox.Error{Kind: aisandbox.ErrUnknownMessage, Frame: frame.Header}
+		}
+	}
+}
+
+// Encodes v as a single line payload:
+//
+//	[]byte, json.RawMessage  sent as they are, compacted to one line
+//	*aisandbox.LevelInfo     aisandbox.EncodeLevelInfo
+//	*aisandbox.GameInfo      aisandbox.EncodeGameInfo, flags are named after their team
+//	anything else            json.Marshal, so it should already be in the wire format
+func Payload(v interface{}) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+	switch v := v.(type) {
+	case []byte:
+		b = v
+	case json.RawMessage:
+		b = v
+	case *aisandbox.GameInfo:
+		b, err = aisandbox.EncodeGameInfo(v)
+	case aisandbox.GameInfo:
+		b, err = aisandbox.EncodeGameInfo(&v)
+	case *aisandbox.LevelInfo:
+		b, err = aisandbox.EncodeLevelInfo(v)
+	default:
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err = json.Compact(&buffer, b); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Writes one frame. Errors are of type *aisandbox.Error.
+func (c *Conn) send(header string, payloads ...interface{}) error {
+	lines := make([][]byte, len(payloads))
+	for i, v := range payloads {
+		b, err := Payload(v)
+		if err != nil {
+			return &aisandbox.Error{Kind: aisandbox.ErrWrite, Frame: header, Err: err}
+		}
+		lines[i] = b
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.rw.Write(aisandbox.NewFrame(header, lines...).Raw); err != nil {
+		return &aisandbox.Error{Kind: aisandbox.ErrWrite, Frame: header, Err: err}
+	}
+	return nil
+}
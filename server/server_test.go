@@ -0,0 +1,188 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/errnoh/aisandbox"
+)
+
+// Smallest GameInfo the client accepts: one bot and a flag per team.
+const json_game = `{"__class__": "GameInfo", "__value__": {
+	"teams": {
+		"Blue": {"__class__": "TeamInfo", "__value__": {"name": "Blue", "flag": "BlueFlag", "members": ["Blue0"], "flagScoreLocation": [82, 20], "flagSpawnLocation": [82, 20], "botSpawnArea": [[79, 2], [85, 9]]}},
+		"Red": {"__class__": "TeamInfo", "__value__": {"name": "Red", "flag": "RedFlag", "members": ["Red0"], "flagScoreLocation": [6, 30], "flagSpawnLocation": [6, 30], "botSpawnArea": [[3, 41], [9, 48]]}}
+	},
+	"flags": {
+		"BlueFlag": {"__class__": "FlagInfo", "__value__": {"name": "BlueFlag", "team": "Blue", "position": [82, 20], "carrier": null, "respawnTimer": 0}},
+		"RedFlag": {"__class__": "FlagInfo", "__value__": {"name": "RedFlag", "team": "Red", "position": [6, 30], "carrier": null, "respawnTimer": 0}}
+	},
+	"bots": {
+		"Blue0": {"__class__": "BotInfo", "__value__": {"name": "Blue0", "team": "Blue", "position": [81, 19], "facingDirection": [1, 0], "state": 1, "health": 100, "seenBy": [], "visibleEnemies": [], "flag": null, "seenlast": null, "currentAction": null}},
+		"Red0": {"__class__": "BotInfo", "__value__": {"name": "Red0", "team": "Red", "position": [5, 29], "facingDirection": [1, 0], "state": 1, "health": 100, "seenBy": [], "visibleEnemies": [], "flag": null, "seenlast": null, "currentAction": null}}
+	},
+	"team": "Blue",
+	"enemyTeam": "Red",
+	"match": {"__class__": "MatchInfo", "__value__": {"timeRemaining": 180, "timeToNextRespawn": 10, "timePassed": 0, "combatEvents": [], "scores": {"Blue": 0, "Red": 0}}}
+}}`
+
+func TestMatch(t *testing.T) {
+	serverEnd, clientEnd := net.Pipe()
+	cl := aisandbox.Open(context.Background(), clientEnd, "Tester")
+	go func() {
+		for ev := range cl.Events() {
+			switch ev.(type) {
+			case *aisandbox.GameStarted:
+				cl.Out() <- aisandbox.NewMove("Blue0", "", []float64{10, 10})
+				cl.Ready()
+			case *aisandbox.Tick:
+				cl.Out() <- aisandbox.NewDefend("Blue0", "", nil)
+			}
+		}
+		cl.Close()
+	}()
+
+	conn, err := Handshake(serverEnd, "1.4")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	if conn.Name != "Tester" || conn.Language != "Go" {
+		t.Errorf("Expected Tester in Go, got %s in %s", conn.Name, conn.Language)
+	}
+
+	level := &aisandbox.LevelInfo{Width: 88, Height: 50, InitializationTime: 5}
+	if err = conn.Initialize(level, []byte(json_game)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var classes []string
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if msg.Ready {
+			break
+		}
+		classes = append(classes, msg.Class)
+	}
+	game, err := aisandbox.LookupCodec("1.4").DecodeGameInfo([]byte(json_game))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = conn.Tick(game); err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg, err := conn.Read()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	classes = append(classes, msg.Class)
+	if defend, ok := msg.Command.(*aisandbox.Defend); !ok || defend.Bot != "Blue0" {
+		t.Errorf("Expected Defend for Blue0, got %#v", msg.Command)
+	}
+	conn.Shutdown()
+
+	if len(classes) != 2 || classes[0] != "Move" || classes[1] != "Defend" {
+		t.Errorf("Expected Move before <ready> and Defend after the tick, got %v", classes)
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	serverEnd, clientEnd := net.Pipe()
+	go func() {
+		defer clientEnd.Close()
+		buf := make([]byte, 1024)
+		clientEnd.Read(buf)
+		clientEnd.Write([]byte("<connect>\n{\"__class__\": \"Hello\", \"__value__\": {}}\n"))
+	}()
+	if _, err := Handshake(serverEnd, "1.4"); !errors.Is(err, aisandbox.ErrUnexpected) {
+		t.Errorf("Expected ErrUnexpected, got %v", err)
+	}
+}
+
+func TestAcceptSilent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer l.Close()
+	srv := &Server{Listener: l, Version: "1.4", HandshakeTimeout: time.Millisecond * 50}
+
+	// Connects but never answers <connect>.
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer silent.Close()
+	if _, err = srv.Accept(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the silent commander to time out, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cl := aisandbox.Open(context.Background(), conn, "Tester")
+	defer cl.Close()
+	go func() {
+		for ev := range cl.Events() {
+			if _, ok := ev.(*aisandbox.GameStarted); ok {
+				cl.Ready()
+			}
+		}
+	}()
+	c, err := srv.Accept()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer c.Close()
+	if c.Name != "Tester" {
+		t.Errorf("Expected Tester, got %s", c.Name)
+	}
+
+	// The deadline is gone once the handshake is done.
+	time.Sleep(time.Millisecond * 100)
+	level := &aisandbox.LevelInfo{Width: 88, Height: 50, InitializationTime: 5}
+	if err = c.Initialize(level, []byte(json_game)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if msg, err := c.Read(); err != nil || !msg.Ready {
+		t.Errorf("Expected <ready>, got %+v (%v)", msg, err)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	if b, err := Payload([]byte("{\n  \"a\": 1\n}")); err != nil || string(b) != `{"a":1}` {
+		t.Errorf("Expected compacted JSON, got %s (%v)", b, err)
+	}
+	if b, err := Payload(&aisandbox.LevelInfo{Width: 88}); err != nil || !strings.HasPrefix(string(b), `{"__class__":"LevelInfo","__value__":{`) {
+		t.Errorf("Expected wrapped LevelInfo, got %s (%v)", b, err)
+	}
+	if _, err := Payload(new(aisandbox.GameInfo)); err == nil {
+		t.Errorf("Expected a GameInfo without teams to be refused")
+	}
+
+	codec := aisandbox.LookupCodec("1.4")
+	game, err := codec.DecodeGameInfo([]byte(json_game))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b, err := Payload(game)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decoded, err := codec.DecodeGameInfo(b); err != nil || !reflect.DeepEqual(decoded, game) {
+		t.Errorf("Expected typed GameInfo to decode back to itself, got %s (%v)", b, err)
+	}
+}
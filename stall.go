@@ -0,0 +1,112 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"fmt"
+	"time"
+)
+
+// NOTE: This file contains the detection of a server that stops sending without closing the connection.
+
+// Sent when the server hasn't sent anything for longer than StallTimeout() allows.
+// Sent once per silence, the session goes on unless AbortOnStall() is used.
+// NOTE: Time the reader spends waiting for the commander to take events doesn't count.
+type Stalled struct {
+	Silence      time.Duration // time since the last frame
+	Initializing bool          // no <tick> had been received yet
+}
+
+func (*Stalled) isEvent() {}
+
+// Send a Stalled event when the server goes silent for longer than between between frames,
+// or initialization before the first <tick>, which should allow for LevelInfo.InitializationTime.
+// Zero disables the check for that phase.
+func StallTimeout(between, initialization time.Duration) Option {
+	return func(o *options) {
+		o.stallTimeout = between
+		o.initTimeout = initialization
+	}
+}
+
+// End the session with an ErrStalled error when the server stalls, see StallTimeout().
+// The commander gets Stalled followed by Disconnected.
+func AbortOnStall() Option {
+	return func(o *options) {
+		o.abortOnStall = true
+	}
+}
+
+// Called by the reader around reading each frame.
+func (cl *Client) waitingFrame(waiting bool) {
+	if waiting {
+		cl.waitingSince.Store(time.Now().UnixNano())
+	} else {
+		cl.waitingSince.Store(0)
+	}
+}
+
+// Limit for the current phase of the session, 0 if not checked.
+func (cl *Client) stallLimit() time.Duration {
+	if cl.ticking.Load() {
+		return cl.opts.stallTimeout
+	}
+	return cl.opts.initTimeout
+}
+
+// The channel of the watchdog timer, nil if stall detection is off.
+// NOTE: Only dispatch() may call this.
+func (cl *Client) watchdogC() <-chan time.Time {
+	if cl.watchdog == nil {
+		return nil
+	}
+	return cl.watchdog.C
+}
+
+// Called by dispatch() when the watchdog fires at now. Keeps a Stalled event for dispatch() to deliver
+// and sets the timer for the next check.
+func (cl *Client) watch(now time.Time) {
+	ev, next := cl.checkStall(now)
+	if ev != nil {
+		cl.stalls = append(cl.stalls, ev)
+	}
+	cl.watchdog.Reset(next)
+}
+
+// Called by watch. Returns a Stalled event if the server stalled,
+// and when to check next.
+func (cl *Client) checkStall(now time.Time) (ev *Stalled, next time.Duration) {
+	limit := cl.stallLimit()
+	since := cl.waitingSince.Load()
+	if limit <= 0 || since == 0 {
+		// Not waiting for the server right now, or not checked in this phase.
+		return nil, cl.watchdogInterval()
+	}
+
+	silence := now.Sub(time.Unix(0, since))
+	if silence < limit {
+		return nil, limit - silence
+	}
+	if since == cl.stalledSince {
+		// Already told about this silence.
+		return nil, limit
+	}
+	cl.stalledSince = since
+
+	ev = &Stalled{Silence: silence, Initializing: !cl.ticking.Load()}
+	if cl.opts.abortOnStall {
+		cl.fail(&Error{Kind: ErrStalled, Err: fmt.Errorf("no frames for %s", silence.Round(time.Millisecond))})
+	}
+	return ev, limit
+}
+
+// Default time between watchdog checks, 0 if stall detection is off.
+func (cl *Client) watchdogInterval() time.Duration {
+	interval := cl.opts.stallTimeout
+	if interval <= 0 || (cl.opts.initTimeout > 0 && cl.opts.initTimeout < interval) {
+		interval = cl.opts.initTimeout
+	}
+	return interval
+}
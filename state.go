@@ -0,0 +1,123 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NOTE: This file contains the bot states and combat event types.
+
+// State of a bot, as in BotInfo.State.
+//
+//	switch bot.State {
+//	case aisandbox.STATE_IDLE:
+//	case aisandbox.STATE_SHOOTING:
+//	}
+type BotState int
+
+// Bot states
+const (
+	STATE_UNKNOWN BotState = iota // not visible, or the server didn't say
+	STATE_IDLE
+	STATE_DEFENDING
+	STATE_MOVING
+	STATE_ATTACKING
+	STATE_CHARGING
+	STATE_SHOOTING
+	STATE_TAKING_ORDERS
+	STATE_HOLDING
+	STATE_DEAD
+
+	TAKING_ORDERS = STATE_TAKING_ORDERS // Deprecated: use STATE_TAKING_ORDERS
+)
+
+var stateNames = []string{"unknown", "idle", "defending", "moving", "attacking", "charging", "shooting", "taking orders", "holding", "dead"}
+
+func (s BotState) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("BotState(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// True for the states of a bot that is known to be alive.
+// NOTE: Some server versions keep sending the last state of a dead bot, BotInfo.IsAlive checks Health as well.
+func (s BotState) IsAlive() bool {
+	return s > STATE_UNKNOWN && s < STATE_DEAD
+}
+
+// True while the bot is doing something, ie. it's alive and not idle.
+func (s BotState) IsBusy() bool {
+	return s.IsAlive() && s != STATE_IDLE
+}
+
+// True while the bot is on its way somewhere.
+func (s BotState) IsMoving() bool {
+	return s == STATE_MOVING || s == STATE_ATTACKING || s == STATE_CHARGING
+}
+
+// True if a command sent to the bot now would be carried out,
+// ie. it's alive and not still taking the previous orders.
+func (s BotState) CanBeOrdered() bool {
+	return s.IsAlive() && s != STATE_TAKING_ORDERS
+}
+
+// Decodes the numeric value sent by the server, null is STATE_UNKNOWN.
+func (s *BotState) UnmarshalJSON(b []byte) error {
+	n, err := unmarshalEnum(b)
+	*s = BotState(n)
+	return err
+}
+
+// True if the bot is alive according to both its state and health.
+func (b *BotInfo) IsAlive() bool {
+	return b.State.IsAlive() && b.Health > 0
+}
+
+// Type of a CombatEvent.
+type EventType int
+
+// CombatEvent types
+const (
+	EVENT_NONE EventType = iota
+	EVENT_KILL
+	EVENT_FLAG_PICKED
+	EVENT_FLAG_DROPPED
+	EVENT_FLAG_CAPTURED
+	EVENT_FLAG_RESTORED
+	EVENT_RESPAWN
+)
+
+var eventNames = []string{"none", "kill", "flag picked", "flag dropped", "flag captured", "flag restored", "respawn"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventNames[t]
+}
+
+// True for the events where CombatEvent.Subject is a flag.
+func (t EventType) IsFlagEvent() bool {
+	return t >= EVENT_FLAG_PICKED && t <= EVENT_FLAG_RESTORED
+}
+
+// Decodes the numeric value sent by the server, null is EVENT_NONE.
+func (t *EventType) UnmarshalJSON(b []byte) error {
+	n, err := unmarshalEnum(b)
+	*t = EventType(n)
+	return err
+}
+
+// The server sends enums as numbers, sometimes as 6.0 instead of 6.
+func unmarshalEnum(b []byte) (int, error) {
+	var f *float64
+	if err := json.Unmarshal(b, &f); err != nil || f == nil {
+		return 0, err
+	}
+	return int(*f), nil
+}
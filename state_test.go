@@ -0,0 +1,71 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBotState(t *testing.T) {
+	tests := []struct {
+		state                             BotState
+		name                              string
+		alive, busy, moving, canBeOrdered bool
+	}{
+		{STATE_UNKNOWN, "unknown", false, false, false, false},
+		{STATE_IDLE, "idle", true, false, false, true},
+		{STATE_CHARGING, "charging", true, true, true, true},
+		{STATE_SHOOTING, "shooting", true, true, false, true},
+		{STATE_TAKING_ORDERS, "taking orders", true, true, false, false},
+		{STATE_DEAD, "dead", false, false, false, false},
+		{BotState(42), "BotState(42)", false, false, false, false},
+	}
+	for _, test := range tests {
+		s := test.state
+		if s.String() != test.name || s.IsAlive() != test.alive || s.IsBusy() != test.busy || s.IsMoving() != test.moving || s.CanBeOrdered() != test.canBeOrdered {
+			t.Errorf("%s: Expected %+v, got alive %v, busy %v, moving %v, can be ordered %v",
+				s, test, s.IsAlive(), s.IsBusy(), s.IsMoving(), s.CanBeOrdered())
+		}
+	}
+	if TAKING_ORDERS != STATE_TAKING_ORDERS {
+		t.Errorf("Expected the old name to keep working")
+	}
+}
+
+func TestEnumJSON(t *testing.T) {
+	var decoded struct {
+		States []BotState  `json:"states"`
+		Events []EventType `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(`{"states": [6, 3.0, null], "events": [2, 6.0]}`), &decoded); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(decoded.States) != 3 || decoded.States[0] != STATE_SHOOTING || decoded.States[1] != STATE_MOVING || decoded.States[2] != STATE_UNKNOWN {
+		t.Errorf("Expected shooting, moving and unknown, got %v", decoded.States)
+	}
+	if len(decoded.Events) != 2 || decoded.Events[0] != EVENT_FLAG_PICKED || !decoded.Events[0].IsFlagEvent() || decoded.Events[1].String() != "respawn" {
+		t.Errorf("Expected flag picked and respawn, got %v", decoded.Events)
+	}
+	if err := json.Unmarshal([]byte(`{"states": ["idle"]}`), &decoded); err == nil {
+		t.Errorf("Expected error for a state that isn't a number")
+	}
+
+	s := new(json_GameInfo)
+	if err := json.Unmarshal([]byte(json_gameinfo), &s); err != nil {
+		t.Fatalf(err.Error())
+	}
+	gi, _ := s.simplify()
+	if blue1 := gi.Team.Members["Blue1"]; blue1.State != STATE_MOVING || !blue1.IsAlive() {
+		t.Errorf("Expected Blue1 to be moving, got %s", blue1.State)
+	}
+	// Dead, but the server still says idle.
+	if blue3 := gi.Team.Members["Blue3"]; blue3.State != STATE_IDLE || blue3.IsAlive() {
+		t.Errorf("Expected Blue3 to be idle and dead, got %s", blue3.State)
+	}
+	if gi.Match.CombatEvents[0].Type != EVENT_KILL {
+		t.Errorf("Expected kill, got %s", gi.Match.CombatEvents[0].Type)
+	}
+}
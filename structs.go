@@ -14,21 +14,21 @@ import (
 // Exported structs that contain the server messages
 
 type LevelInfo struct {
-	Width              float64                `json:"width"`
-	Height             float64                `json:"height"`
-	BlockHeights       [][]float64            `json:"blockHeights"`       // a 'width' list of 'height' lengthed list of integers (BlockHeights[x][y])
-	TeamNames          []string               `json:"teamNames"`          // list of team names
-	FlagSpawnLocations map[string][]float64   `json:"flagSpawnLocations"` // map of team name to position
-	FlagScoreLocations map[string][]float64   `json:"flagScoreLocations"` // map of team name to position
-	BotSpawnAreas      map[string][][]float64 `json:"botSpawnAreas"`      // map of team name to min and max positions
-	FieldOfViewAngles  []float64              `json:"fieldOfViewAngles"`
-	CharacterRadius    float64                `json:"characterRadius"`
-	WalkingSpeed       float64                `json:"walkingSpeed"`
-	RunningSpeed       float64                `json:"runningSpeed"`
-	FiringDistance     float64                `json:"firingDistance"`
-	GameLength         float64                `json:"gameLength"`         // the time (seconds) that a game will last
-	InitializationTime float64                `json:"initializationTime"` // the time (seconds) allowed to the commanders for initialization
-	RespawnTime        float64                `json:"respawnTime"`
+	Width              float64           `json:"width"`
+	Height             float64           `json:"height"`
+	BlockHeights       [][]float64       `json:"blockHeights"`       // a 'width' list of 'height' lengthed list of integers (BlockHeights[x][y])
+	TeamNames          []string          `json:"teamNames"`          // list of team names
+	FlagSpawnLocations map[string]Vec2   `json:"flagSpawnLocations"` // map of team name to position
+	FlagScoreLocations map[string]Vec2   `json:"flagScoreLocations"` // map of team name to position
+	BotSpawnAreas      map[string][]Vec2 `json:"botSpawnAreas"`      // map of team name to min and max positions
+	FieldOfViewAngles  []float64         `json:"fieldOfViewAngles"`
+	CharacterRadius    float64           `json:"characterRadius"`
+	WalkingSpeed       float64           `json:"walkingSpeed"`
+	RunningSpeed       float64           `json:"runningSpeed"`
+	FiringDistance     float64           `json:"firingDistance"`
+	GameLength         float64           `json:"gameLength"`         // the time (seconds) that a game will last
+	InitializationTime float64           `json:"initializationTime"` // the time (seconds) allowed to the commanders for initialization
+	RespawnTime        float64           `json:"respawnTime"`
 }
 
 type GameInfo struct {
@@ -41,14 +41,14 @@ type TeamInfo struct {
 	Name              string
 	Flag              *FlagInfo
 	Members           map[string]*BotInfo
-	FlagSpawnLocation []float64
-	FlagScoreLocation []float64
-	BotSpawnArea      [][]float64
+	FlagSpawnLocation Vec2
+	FlagScoreLocation Vec2
+	BotSpawnArea      []Vec2 // min and max positions
 	Score             float64
 }
 
 type FlagInfo struct {
-	Position     []float64
+	Position     Vec2
 	Carrier      *BotInfo
 	RespawnTimer float64
 }
@@ -56,10 +56,10 @@ type FlagInfo struct {
 type BotInfo struct {
 	Name            string
 	Team            string
-	Position        []float64
-	FacingDirection []float64
+	Position        Vec2 // nil if the bot is not visible
+	FacingDirection Vec2 // nil if the bot is not visible
 	Flag            string
-	State           float64 // values are 0 = unknown, 1 = idle, 2 = defending, 3 = moving, 4 = attacking, 5 = charging, 6 = shooting, 7 = taking orders, 8 = holding
+	State           BotState // one of STATE_*
 	Health          float64
 	SeenLast        float64
 	VisibleEnemies  []*BotInfo
@@ -74,7 +74,7 @@ type MatchInfo struct {
 }
 
 type CombatEvent struct {
-	Type       float64 // values are 0 = none, 1 = bot killed, 2 = flag picked up, 3 = flag dropped 4 = flag captured, 5 = flag restored, 6 = bot respawned
+	Type       EventType // one of EVENT_*
 	Instigator string
 	Subject    string // can either be a FlagInfo or a BotInfo name
 	Time       float64
@@ -86,6 +86,21 @@ type Command interface {
 	JSON() []byte
 }
 
+// Batch sends several commands at once, eg. all the orders of one tick.
+// The whole batch is written to the server with a single flush.
+//
+//	out <- aisandbox.Batch{move, attack, defend}
+type Batch []Command
+
+// Returns the JSON of each command in the batch, one per line.
+func (b Batch) JSON() []byte {
+	var buffer []byte
+	for _, cmd := range b {
+		buffer = append(buffer, cmd.JSON()...)
+	}
+	return buffer
+}
+
 // Since update 1.4 Defend can be passed as many [direction], duration pairs as one wants.
 // That's not really a slice of any actual type, so Defend became a bit more complicated.
 // Because of this, constructors are added to the API.
@@ -98,8 +113,8 @@ type Command interface {
 		Bot:		"Bacon",
 		Description:	"Mmmm",
 		FacingDirections: []FacingDirection{
-			FacingDirection{[]float64{1.2, 2}, 3.7},
-			FacingDirection{[]float64{4, 5.1}, 6.3},
+			FacingDirection{V(1.2, 2), 3.7},
+			FacingDirection{V(4, 5.1), 6.3},
 		},
 	}
 */
@@ -110,7 +125,7 @@ type Defend struct {
 }
 
 type FacingDirection struct {
-	Direction []float64
+	Direction Vec2
 	Duration  float64
 }
 
@@ -135,9 +150,9 @@ func (c *Defend) JSON() []byte {
 }
 
 type Move struct {
-	Bot         string      `json:"bot"`
-	Target      [][]float64 `json:"target"`
-	Description string      `json:"description"`
+	Bot         string `json:"bot"`
+	Target      []Vec2 `json:"target"`
+	Description string `json:"description"`
 }
 
 func (c *Move) JSON() []byte {
@@ -153,10 +168,10 @@ func (c *Move) JSON() []byte {
 }
 
 type Attack struct {
-	Bot         string      `json:"bot"`
-	Target      [][]float64 `json:"target"`
-	LookAt      []float64   `json:"lookAt, omitempty"` // Optional
-	Description string      `json:"description"`
+	Bot         string `json:"bot"`
+	Target      []Vec2 `json:"target"`
+	LookAt      Vec2   `json:"lookAt,omitempty"` // Optional
+	Description string `json:"description"`
 }
 
 func (c *Attack) JSON() []byte {
@@ -172,9 +187,9 @@ func (c *Attack) JSON() []byte {
 }
 
 type Charge struct {
-	Bot         string      `json:"bot"`
-	Target      [][]float64 `json:"target"`
-	Description string      `json:"description"`
+	Bot         string `json:"bot"`
+	Target      []Vec2 `json:"target"`
+	Description string `json:"description"`
 }
 
 func (c *Charge) JSON() []byte {
@@ -6,6 +6,8 @@ package aisandbox
 
 import (
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -60,7 +62,10 @@ func TestSimplify(t *testing.T) {
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	gi := s.simplify()
+	gi, err := s.simplify()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
 
 	test := [][]float64{
 		{float64(s.Value.Bots["Blue3"].Value.Health), gi.Team.Members["Blue3"].Health},
@@ -70,11 +75,77 @@ func TestSimplify(t *testing.T) {
 
 	for _, pair := range test {
 		if pair[0] != pair[1] {
-			t.Errorf("Simplify: Expected %d, got %d", pair[1], pair[0])
+			t.Errorf("Simplify: Expected %f, got %f", pair[1], pair[0])
+		}
+	}
+}
+
+func TestSimplifyInconsistent(t *testing.T) {
+	tests := []struct {
+		name     string
+		break_   func(v *json_GameInfo)
+		expected string
+	}{
+		{"missing bot", func(v *json_GameInfo) { delete(v.Value.Bots, "Blue3") }, `bot "Blue3" of team "Blue" missing from bots`},
+		{"null match", func(v *json_GameInfo) { v.Value.Match = nil }, "match missing"},
+		{"missing team", func(v *json_GameInfo) { delete(v.Value.Teams, "Red") }, `team "Red" missing from teams`},
+		{"missing flag", func(v *json_GameInfo) { delete(v.Value.Flags, "BlueFlag") }, `flag "BlueFlag" of team "Blue" missing from flags`},
+		{"unknown enemy", func(v *json_GameInfo) { delete(v.Value.Bots, "Red2") }, `bot "Blue0" sees unknown bot "Red2"`},
+		{"unknown carrier", func(v *json_GameInfo) { delete(v.Value.Bots, "Blue1") }, `flag "RedFlag" carried by unknown bot "Blue1"`},
+		{"empty combat event", func(v *json_GameInfo) { v.Value.Match.Value.CombatEvents[1] = nil }, "combat event 1 empty"},
+	}
+
+	for _, test := range tests {
+		s := new(json_GameInfo)
+		if err := json.Unmarshal([]byte(json_gameinfo), &s); err != nil {
+			t.Fatalf(err.Error())
+		}
+		test.break_(s)
+		gi, err := s.simplify()
+
+		var inconsistent Inconsistencies
+		if !errors.As(err, &inconsistent) || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: Expected %q, got %v", test.name, test.expected, err)
+		}
+		if gi == nil || gi.Team == nil || gi.EnemyTeam == nil || gi.Match == nil || gi.Team.Flag == nil || gi.EnemyTeam.Flag == nil {
+			t.Errorf("%s: Expected usable GameInfo, got %+v", test.name, gi)
 		}
 	}
 }
 
+// simplify must not panic on anything that decodes, and must always return a usable GameInfo.
+func FuzzSimplify(f *testing.F) {
+	f.Add([]byte(json_gameinfo))
+	f.Add([]byte(`{"__value__": {}}`))
+	f.Add([]byte(`{"__value__": {"team": "Blue", "teams": {"Blue": {"__value__": {"members": ["Blue0"], "flag": "BlueFlag"}}}, "match": null}}`))
+	f.Add([]byte(`{"__value__": {"teams": {"": null}, "bots": {"": null}, "flags": {"": {"__value__": {"carrier": "x"}}}, "match": {"__value__": {"combatEvents": [null, {}]}}}}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s := new(json_GameInfo)
+		if err := json.Unmarshal(b, s); err != nil {
+			return
+		}
+		gi, err := s.simplify()
+		if gi == nil || gi.Team == nil || gi.EnemyTeam == nil || gi.Match == nil || gi.Team.Flag == nil || gi.EnemyTeam.Flag == nil {
+			t.Fatalf("Expected usable GameInfo, got %+v (%v)", gi, err)
+		}
+		if err != nil && len(err.(Inconsistencies)) == 0 {
+			t.Fatalf("Expected inconsistencies to be listed, got %v", err)
+		}
+		for _, team := range []*TeamInfo{gi.Team, gi.EnemyTeam} {
+			for name, bot := range team.Members {
+				if bot == nil {
+					t.Fatalf("Expected no nil members, got nil %q", name)
+				}
+				for _, other := range append(bot.SeenBy, bot.VisibleEnemies...) {
+					if other == nil {
+						t.Fatalf("Expected no nil bots seen by or visible to %q", name)
+					}
+				}
+			}
+		}
+	})
+}
+
 func TestJSON(t *testing.T) {
 	// A bit ugly to test because of the anonymous structs. It's not a problem when actually using it though.
 	expected_li := new(json_LevelInfo)
@@ -84,10 +155,10 @@ func TestJSON(t *testing.T) {
 	expected_li.Value.Height = 50
 	expected_li.Value.BlockHeights = [][]float64{{1, 2, 3}, {4, 5}, {6, 7, 8, 9}}
 	expected_li.Value.TeamNames = []string{"Blue", "Red"}
-	expected_li.Value.FlagSpawnLocations = map[string][]float64{"Blue": {82.0, 20.0}, "Red": {6.0, 30.0}}
-	expected_li.Value.FlagScoreLocations = map[string][]float64{"Blue": {82.0, 20.0}, "Red": {6.0, 30.0}}
-	expected_li.Value.BotSpawnAreas = map[string][][]float64{"Blue": {{79.0, 2.0}, {85.0, 9.0}}, "Red": {{3.0, 41.0}, {9.0, 48.0}}}
-	expected_li.Value.FOVangle = 1.5707963267948966
+	expected_li.Value.FlagSpawnLocations = map[string]Vec2{"Blue": {82.0, 20.0}, "Red": {6.0, 30.0}}
+	expected_li.Value.FlagScoreLocations = map[string]Vec2{"Blue": {82.0, 20.0}, "Red": {6.0, 30.0}}
+	expected_li.Value.BotSpawnAreas = map[string][]Vec2{"Blue": {{79.0, 2.0}, {85.0, 9.0}}, "Red": {{3.0, 41.0}, {9.0, 48.0}}}
+	expected_li.Value.FieldOfViewAngles = []float64{1.5707963267948966}
 	expected_li.Value.CharacterRadius = 0.25
 	expected_li.Value.WalkingSpeed = 3.0
 	expected_li.Value.RunningSpeed = 6.0
@@ -104,7 +175,7 @@ func TestJSON(t *testing.T) {
 		{li.Value.BlockHeights[0][1], expected_li.Value.BlockHeights[0][1]},
 		{li.Value.InitializationTime, expected_li.Value.InitializationTime},
 		{li.Value.Width, expected_li.Value.Width},
-		{li.Value.FOVangle, expected_li.Value.FOVangle},
+		{li.Value.FieldOfViewAngles[0], expected_li.Value.FieldOfViewAngles[0]},
 		{li.Value.CharacterRadius, expected_li.Value.CharacterRadius},
 		{li.Value.BotSpawnAreas["Blue"][0][1], expected_li.Value.BotSpawnAreas["Blue"][0][1]},
 	}
@@ -116,8 +187,22 @@ func TestJSON(t *testing.T) {
 	}
 }
 
+// Optional fields are left out when empty, not sent as null.
+func TestOmitEmpty(t *testing.T) {
+	attack := NewAttack("Blue0", "", nil, []float64{1, 2})
+	if b, _ := json.Marshal(attack); string(b) != `{"bot":"Blue0","target":[[1,2]],"description":""}` {
+		t.Errorf("Expected no lookAt, got %s", b)
+	}
+	if b, _ := json.Marshal(new(json_BotInfo)); string(b) != `{"__class__":"","__value__":{"name":"","team":"","visibleEnemies":null,"seenBy":null}}` {
+		t.Errorf("Expected no optional BotInfo fields, got %s", b)
+	}
+	if b, _ := json.Marshal(new(json_CombatEvent)); string(b) != `{"type":0,"subject":"","time":0}` {
+		t.Errorf("Expected no instigator, got %s", b)
+	}
+}
+
 var (
-	json_levelinfo = `{"__class__": "LevelInfo", "__value__": {"runningSpeed": 6.0, "flagSpawnLocations": {"Blue": [82.0, 20.0], "Red": [6.0, 30.0]}, "teamNames": ["Blue", "Red"], "blockHeights": [[1,2,3],[4,5],[6,7,8,9]], "height": 50, "characterRadius": 0.25, "walkingSpeed": 3.0, "FOVangle": 1.5707963267948966, "botSpawnAreas": {"Blue": [[79.0, 2.0], [85.0, 9.0]], "Red": [[3.0, 41.0], [9.0, 48.0]]}, "firingDistance": 15.0, "width": 88, "flagScoreLocations": {"Blue": [82.0, 20.0], "Red": [6.0, 30.0]}, "gameLength": 180.0, "initializationTime": 10.0}}
+	json_levelinfo = `{"__class__": "LevelInfo", "__value__": {"runningSpeed": 6.0, "flagSpawnLocations": {"Blue": [82.0, 20.0], "Red": [6.0, 30.0]}, "teamNames": ["Blue", "Red"], "blockHeights": [[1,2,3],[4,5],[6,7,8,9]], "height": 50, "characterRadius": 0.25, "walkingSpeed": 3.0, "fieldOfViewAngles": [1.5707963267948966], "botSpawnAreas": {"Blue": [[79.0, 2.0], [85.0, 9.0]], "Red": [[3.0, 41.0], [9.0, 48.0]]}, "firingDistance": 15.0, "width": 88, "flagScoreLocations": {"Blue": [82.0, 20.0], "Red": [6.0, 30.0]}, "gameLength": 180.0, "initializationTime": 10.0}}
 `
 
 	json_init = `<initialize>
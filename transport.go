@@ -0,0 +1,75 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+)
+
+// NOTE: This file contains the transports a session can run over.
+
+// Dialer opens the connection to the server for ConnectContext.
+type Dialer interface {
+	Dial(ctx context.Context, addr string) (io.ReadWriteCloser, error)
+}
+
+// Dials with the net package. Network defaults to "tcp", use "unix" for Unix domain sockets.
+type NetDialer struct {
+	Network string
+}
+
+func (d NetDialer) Dial(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+	var dialer net.Dialer
+	network := d.Network
+	if network == "" {
+		network = "tcp"
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+type stdio struct {
+	in      *os.File
+	out     io.Writer
+	restore func() // see pollable
+}
+
+func (s stdio) Read(b []byte) (int, error) {
+	return s.in.Read(b)
+}
+
+func (s stdio) Write(b []byte) (int, error) {
+	return s.out.Write(b)
+}
+
+// Closes the session's copy of stdin, which also interrupts a pending Read,
+// and puts stdin back into blocking mode. Stdout belongs to the process, it's left open.
+func (s stdio) Close() error {
+	err := s.in.Close()
+	s.restore()
+	return err
+}
+
+// Standard input and output as a connection, for bots that are spawned as a child process
+// by the match runner:
+//
+//	client := aisandbox.Open(ctx, aisandbox.Stdio(), "ChildBot")
+//
+// The session reads a non-blocking copy of stdin, so don't read os.Stdin yourself.
+// The non-blocking mode is shared with os.Stdin until the session is closed, so close it
+// before exiting, or the shell may be left with a non-blocking terminal.
+// Closing the session closes that copy only, os.Stdin and os.Stdout stay open.
+// NOTE: Log to stderr when using this, anything else written to stdout breaks the protocol.
+// NOTE: Outside Unix the session reads and closes os.Stdin itself, and closing doesn't interrupt a pending read.
+func Stdio() io.ReadWriteCloser {
+	return newStdio(os.Stdin, os.Stdout)
+}
+
+func newStdio(in *os.File, out io.Writer) stdio {
+	dup, restore := pollable(in)
+	return stdio{dup, out, restore}
+}
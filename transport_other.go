@@ -0,0 +1,18 @@
+//go:build !unix
+
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"os"
+)
+
+// NOTE: This file contains the transports of the platforms without non-blocking stdin.
+
+// Only Unix descriptors are made non-blocking, elsewhere closing f doesn't interrupt a pending Read.
+func pollable(f *os.File) (dup *os.File, restore func()) {
+	return f, func() {}
+}
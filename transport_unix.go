@@ -0,0 +1,34 @@
+//go:build unix
+
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// NOTE: This file contains the Unix specific parts of the transports.
+
+// Non-blocking duplicate of f, which the runtime poller can interrupt: closing it unblocks a pending Read,
+// unlike closing a blocking file. The non-blocking mode is shared with f, so restore puts f back
+// into blocking mode once the duplicate is closed.
+// Falls back to f itself if the descriptor can't be duplicated.
+func pollable(f *os.File) (dup *os.File, restore func()) {
+	// Fd() puts f into blocking mode, which is what restore goes back to.
+	orig := int(f.Fd())
+	fd, err := syscall.Dup(orig)
+	if err != nil {
+		return f, func() {}
+	}
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return f, func() {}
+	}
+	return os.NewFile(uintptr(fd), f.Name()), func() {
+		syscall.SetNonblock(orig, false)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin
+
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func nonblocking(fd uintptr) bool {
+	flags, _, _ := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	return flags&syscall.O_NONBLOCK != 0
+}
+
+func TestStdioRestoresBlocking(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Fd() puts the file into blocking mode, so it's called only once.
+	fd := r.Fd()
+	s := newStdio(r, w)
+	if !nonblocking(fd) {
+		t.Errorf("Expected the copy to make the shared descriptor non-blocking")
+	}
+	s.Close()
+	if nonblocking(fd) {
+		t.Errorf("Expected Close to put the descriptor back into blocking mode")
+	}
+}
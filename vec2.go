@@ -0,0 +1,131 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// NOTE: This file contains the Vec2 type used for positions and directions.
+
+// Vec2 is a position or a direction, [x, y] like the server sends them.
+//
+// It's a []float64 underneath, so old code keeps working: []float64{1, 2} can be passed
+// where Vec2 is expected and Vec2 can be assigned to a []float64.
+// nil means that the value is unknown, eg. the position of a bot that isn't visible.
+// NOTE: The methods expect two components, like indexing they panic on nil.
+type Vec2 []float64
+
+// Returns Vec2{x, y}.
+func V(x, y float64) Vec2 {
+	return Vec2{x, y}
+}
+
+func (v Vec2) X() float64 {
+	return v[0]
+}
+
+func (v Vec2) Y() float64 {
+	return v[1]
+}
+
+func (v Vec2) Add(o Vec2) Vec2 {
+	return Vec2{v[0] + o[0], v[1] + o[1]}
+}
+
+func (v Vec2) Sub(o Vec2) Vec2 {
+	return Vec2{v[0] - o[0], v[1] - o[1]}
+}
+
+func (v Vec2) Scale(s float64) Vec2 {
+	return Vec2{v[0] * s, v[1] * s}
+}
+
+func (v Vec2) Length() float64 {
+	return math.Hypot(v[0], v[1])
+}
+
+// Distance between positions v and o.
+func (v Vec2) Distance(o Vec2) float64 {
+	return math.Hypot(o[0]-v[0], o[1]-v[1])
+}
+
+// Returns v scaled to length 1, zero vector stays as it is.
+func (v Vec2) Normalize() Vec2 {
+	l := v.Length()
+	if l == 0 {
+		return Vec2{0, 0}
+	}
+	return Vec2{v[0] / l, v[1] / l}
+}
+
+func (v Vec2) Dot(o Vec2) float64 {
+	return v[0]*o[0] + v[1]*o[1]
+}
+
+// Z component of the cross product, positive if o is counterclockwise from v.
+func (v Vec2) Cross(o Vec2) float64 {
+	return v[0]*o[1] - v[1]*o[0]
+}
+
+// Angle of v in radians from the positive x axis, in range [-Pi, Pi].
+func (v Vec2) Angle() float64 {
+	return math.Atan2(v[1], v[0])
+}
+
+// Returns v rotated counterclockwise by radians.
+func (v Vec2) Rotate(radians float64) Vec2 {
+	sin, cos := math.Sincos(radians)
+	return Vec2{v[0]*cos - v[1]*sin, v[0]*sin + v[1]*cos}
+}
+
+// Linear interpolation from v (t = 0) to o (t = 1).
+func (v Vec2) Lerp(o Vec2, t float64) Vec2 {
+	return Vec2{v[0] + (o[0]-v[0])*t, v[1] + (o[1]-v[1])*t}
+}
+
+func (v Vec2) String() string {
+	if v == nil {
+		return "[]"
+	}
+	return fmt.Sprintf("[%g, %g]", v[0], v[1])
+}
+
+// Encodes as [x, y], or null if v is nil.
+func (v Vec2) MarshalJSON() ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	if len(v) != 2 {
+		return nil, fmt.Errorf("Invalid Vec2, expected [x, y], got %d values", len(v))
+	}
+	return json.Marshal([]float64(v))
+}
+
+func (v *Vec2) UnmarshalJSON(b []byte) error {
+	var xy []float64
+	if err := json.Unmarshal(b, &xy); err != nil {
+		return err
+	}
+	if xy != nil && len(xy) != 2 {
+		return fmt.Errorf("Invalid Vec2, expected [x, y], got %d values", len(xy))
+	}
+	*v = xy
+	return nil
+}
+
+// Converts old style [][]float64 coordinates to []Vec2.
+func Vec2s(coordinates [][]float64) []Vec2 {
+	if coordinates == nil {
+		return nil
+	}
+	vs := make([]Vec2, len(coordinates))
+	for i, c := range coordinates {
+		vs[i] = c
+	}
+	return vs
+}
@@ -0,0 +1,100 @@
+// This file is part of The AI Sandbox Go Bindings by errnoh.
+// Copyright (c) 2012, errnoh@github
+// License: See LICENSE file.
+
+package aisandbox
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestVec2(t *testing.T) {
+	a, b := V(3, 4), V(1, -2)
+	near := func(x, y float64) bool {
+		return math.Abs(x-y) < 1e-9
+	}
+	equal := func(v, o Vec2) bool {
+		return near(v.X(), o.X()) && near(v.Y(), o.Y())
+	}
+
+	vectors := []struct {
+		name          string
+		got, expected Vec2
+	}{
+		{"Add", a.Add(b), V(4, 2)},
+		{"Sub", a.Sub(b), V(2, 6)},
+		{"Scale", a.Scale(2), V(6, 8)},
+		{"Normalize", a.Normalize(), V(0.6, 0.8)},
+		{"Normalize zero", V(0, 0).Normalize(), V(0, 0)},
+		{"Rotate", V(1, 0).Rotate(math.Pi / 2), V(0, 1)},
+		{"Lerp", a.Lerp(b, 0.5), V(2, 1)},
+	}
+	for _, test := range vectors {
+		if !equal(test.got, test.expected) {
+			t.Errorf("%s: Expected %v, got %v", test.name, test.expected, test.got)
+		}
+	}
+
+	scalars := []struct {
+		name          string
+		got, expected float64
+	}{
+		{"Length", a.Length(), 5},
+		{"Distance", a.Distance(b), math.Sqrt(40)},
+		{"Dot", a.Dot(b), -5},
+		{"Cross", a.Cross(b), -10},
+		{"Angle", V(0, -1).Angle(), -math.Pi / 2},
+	}
+	for _, test := range scalars {
+		if !near(test.got, test.expected) {
+			t.Errorf("%s: Expected %f, got %f", test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestVec2JSON(t *testing.T) {
+	var decoded struct {
+		Position Vec2 `json:"position"`
+		Unknown  Vec2 `json:"unknown"`
+	}
+	if err := json.Unmarshal([]byte(`{"position": [1.5, 2], "unknown": null}`), &decoded); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !equalVec2(decoded.Position, V(1.5, 2)) || decoded.Unknown != nil {
+		t.Errorf("Expected [1.5, 2] and nil, got %v and %v", decoded.Position, decoded.Unknown)
+	}
+	if b, err := json.Marshal(decoded); err != nil || string(b) != `{"position":[1.5,2],"unknown":null}` {
+		t.Errorf("Expected [x, y] and null, got %s (%v)", b, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"position": [1, 2, 3]}`), &decoded); err == nil {
+		t.Errorf("Expected error for 3 values")
+	}
+	if _, err := json.Marshal(Vec2{1}); err == nil {
+		t.Errorf("Expected error for 1 value")
+	}
+}
+
+func TestVec2Constructors(t *testing.T) {
+	// Old style slices still work.
+	move := NewMove("Blue0", "", []float64{1, 2}, V(3, 4))
+	if string(move.JSON()) != `{"__class__":"Move","__value__":{"bot":"Blue0","target":[[1,2],[3,4]],"description":""}}`+"\n" {
+		t.Errorf("Unexpected JSON %s", move.JSON())
+	}
+
+	if attack := NewAttack("Blue0", "", nil, V(1, 2)); attack.LookAt != nil {
+		t.Errorf("Expected no LookAt, got %v", attack.LookAt)
+	}
+	if attack := NewAttack("Blue0", "", []float64{1}, V(1, 2)); attack.LookAt != nil {
+		t.Errorf("Expected invalid LookAt to be ignored, got %v", attack.LookAt)
+	}
+	if attack := NewAttack("Blue0", "", V(5, 6), V(1, 2)); !equalVec2(attack.LookAt, V(5, 6)) {
+		t.Errorf("Expected LookAt [5, 6], got %v", attack.LookAt)
+	}
+}
+
+func equalVec2(v, o Vec2) bool {
+	return len(v) == 2 && len(o) == 2 && v[0] == o[0] && v[1] == o[1]
+}